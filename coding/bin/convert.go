@@ -4,32 +4,18 @@ import (
 	"io/ioutil"
 	"flag"
 	"os"
+	"strings"
 
 	mc "github.com/jbenet/go-multicodec"
-	pb "github.com/ipfs/go-ipld/coding/pb"
 	ipld "github.com/ipfs/go-ipld"
 	coding "github.com/ipfs/go-ipld/coding"
 )
 
-var codecs []mc.Multicodec = []mc.Multicodec{
-	coding.CborMulticodec(),
-	coding.JsonMulticodec(),
-	pb.Multicodec(),
-}
-
-func codecByName(name string) mc.Multicodec {
-	for _, c := range codecs {
-		if name == string(mc.HeaderPath(c.Header())) {
-			return c
-		}
-	}
-	return nil
-}
-
 func main() {
 	infile  := flag.String("i", "", "Input file")
 	outfile := flag.String("o", "", "Output file")
-	codecid := flag.String("c", "", "Multicodec to use")
+	codecid := flag.String("c", "", "Multicodec to use: "+codecNames())
+	indent  := flag.String("indent", "", "Pretty print /json output with this indent")
 	flag.Parse()
 	file, err := ioutil.ReadFile(*infile)
 	if err != nil {
@@ -43,9 +29,12 @@ func main() {
 		panic(err)
 	}
 
-	codec = codecByName(*codecid)
+	codec = coding.LookupByName(*codecid)
+	if *indent != "" && *codecid == "/json" {
+		codec = coding.PrettyJsonMulticodec(*indent)
+	}
 	if codec == nil {
-		panic("Could not find codec " + *codecid)
+		panic("Could not find codec " + *codecid + ", available: " + codecNames())
 	}
 
 	delete(n, ipld.CodecKey)
@@ -68,3 +57,12 @@ func main() {
 }
 
 
+
+// codecNames lists the header paths of the registered codecs.
+func codecNames() string {
+	var names []string
+	for _, c := range coding.List() {
+		names = append(names, string(mc.HeaderPath(c.Header())))
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,113 @@
+package ipfsld
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	ipld "github.com/ipfs/go-ipld"
+	pb "github.com/ipfs/go-ipld/coding/pb"
+
+	mc "github.com/jbenet/go-multicodec"
+)
+
+func TestJsonBytes(t *testing.T) {
+	n := ipld.Node{
+		ipld.CodecKey: "/json",
+		"data":        []byte("\x00\x01binary\xff"),
+		"list":        []interface{}{[]byte{}, "string"},
+		"link":        ipld.Node{ipld.LinkKey: "QmZku7P7KeeHAnwMr6c4HveYfMzmtVinNXzibkiNbfDbPo"},
+	}
+
+	encoded, err := mc.Marshal(Multicodec(), &n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(encoded, []byte(`"data":{"@bytes":"AAFiaW5hcnn/"}`)) {
+		t.Errorf("bytes not encoded with the reserved shape: %s", encoded)
+	}
+
+	var n2 ipld.Node
+	if err := mc.Unmarshal(Multicodec(), encoded, &n2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(n, n2) {
+		t.Logf("Expected: %#v", n)
+		t.Logf("Actual:   %#v", n2)
+		t.Error("bytes did not round-trip through JSON")
+	}
+
+	reserved := ipld.Node{
+		ipld.CodecKey: "/json",
+		"fake":        ipld.Node{ipld.BytesKey: "AAFiaW5hcnn/"},
+	}
+	if _, err := mc.Marshal(Multicodec(), &reserved); err != errJsonReservedBytes {
+		t.Errorf("expected errJsonReservedBytes, got %v", err)
+	}
+}
+
+// Test converting a protobuf node to every codec and back
+func TestProtobufRoundTrip(t *testing.T) {
+	pbfile, err := ioutil.ReadFile("pb/testfile")
+	if err != nil {
+		t.Fatal("could not read pb/testfile. please run: make -C pb testfile")
+	}
+
+	var n ipld.Node
+	if err := mc.Unmarshal(pb.Multicodec(), pbfile, &n); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, codec := range []mc.Multicodec{JsonMulticodec(), CborMulticodec()} {
+		encoded, err := mc.Marshal(codec, &n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var n2 ipld.Node
+		if err := mc.Unmarshal(codec, encoded, &n2); err != nil {
+			t.Fatal(err)
+		}
+
+		pbencoded, err := mc.Marshal(pb.Multicodec(), &n2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(pbfile, pbencoded) {
+			t.Errorf("protobuf node changed through %s", mc.HeaderPath(codec.Header()))
+		}
+	}
+}
+
+// Test that nodes with @attrs which are not merkledag protobuf nodes still
+// use the default codec
+func TestAttrsDefaultCodec(t *testing.T) {
+	for _, n := range []ipld.Node{
+		{
+			"@attrs": ipld.Node{"@container": "@list"},
+			"items":  []interface{}{"a", "b"},
+		},
+		{
+			"@attrs": ipld.Node{"data": []byte("data")},
+			"title":  "not a link",
+		},
+	} {
+		encoded, err := mc.Marshal(Multicodec(), &n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(encoded, append(Multicodec().Header(), CborMulticodec().Header()...)) {
+			t.Errorf("not encoded with the default codec: %q", encoded)
+		}
+
+		var n2 ipld.Node
+		if err := mc.Unmarshal(Multicodec(), encoded, &n2); err != nil {
+			t.Fatal(err)
+		}
+		delete(n2, ipld.CodecKey)
+		if !reflect.DeepEqual(n, n2) {
+			t.Errorf("decoded as %#v", n2)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+// Package codectest provides conformance tests for the codecs used with IPLD
+// nodes. Any codec, including third-party ones, can be tested with:
+//
+//	func TestConformance(t *testing.T) {
+//	  codectest.Run(t, codectest.Config{
+//	    Codec:       MyMulticodec(),
+//	    FixturesDir: "testdata",
+//	  })
+//	}
+//
+// The encoded fixtures are generated by running the tests with the
+// -codectest.update flag, and are meant to be checked in.
+package codectest
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	mc "github.com/jbenet/go-multicodec"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+var update = flag.Bool("codectest.update", false, "write the encoded fixtures instead of checking them")
+
+// Nodes are the nodes any general purpose codec should support.
+var Nodes = map[string]ipld.Node{
+	"empty": {},
+	"scalars": {
+		"string": "foo",
+		"int":    int64(-3),
+		"uint":   int64(42),
+		"float":  2.5,
+		"true":   true,
+		"false":  false,
+		"null":   nil,
+	},
+	"nested": {
+		"a": ipld.Node{
+			"b": []interface{}{
+				"c",
+				ipld.Node{"d": "e"},
+				[]interface{}{},
+			},
+		},
+	},
+	"bytes": {
+		"bytes": []byte("\x00\x01binary\xff"),
+		"empty": []byte{},
+	},
+	"links": {
+		"foo": ipld.Node{
+			ipld.LinkKey: "QmZku7P7KeeHAnwMr6c4HveYfMzmtVinNXzibkiNbfDbPo",
+		},
+		"bar": ipld.Node{
+			"baz": ipld.Node{
+				ipld.LinkKey: "QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39V",
+				"size":       int64(12),
+			},
+		},
+		"list": []interface{}{
+			ipld.Node{ipld.LinkKey: "QmZku7P7KeeHAnwMr6c4HveYfMzmtVinNXzibkiNbfDbPo"},
+		},
+	},
+	"directives": {
+		"@type":    "commit",
+		"@context": "/ipfs/QmZku7P7KeeHAnwMr6c4HveYfMzmtVinNXzibkiNbfDbPo/mdag",
+		"\\@foo":   "escaped",
+	},
+}
+
+// Config describes the codec under test.
+type Config struct {
+	// Codec is the codec under test. It must encode and decode *ipld.Node.
+	Codec mc.Multicodec
+
+	// Nodes are the nodes the codec is tested with. If nil, the package
+	// Nodes are used.
+	Nodes map[string]ipld.Node
+
+	// FixturesDir, if not empty, is the directory holding the encoded
+	// nodes, one file per node named <node>.<codec>, where <codec> is the
+	// header path of the codec.
+	FixturesDir string
+
+	// RawFallback tells that the codec decodes the objects lacking its
+	// header as raw nodes, whose content is the whole object, as
+	// Multicodec() does. Such objects are then expected to decode to raw
+	// nodes rather than to fail.
+	RawFallback bool
+
+	// Unterminated tells that the encoding has no end marker, so that some
+	// truncated objects are valid objects themselves, as with YAML. The
+	// truncated objects are then not tested.
+	Unterminated bool
+}
+
+// check is one of the conformance tests run by Run.
+type check struct {
+	name string
+	run  func(*testing.T, Config)
+}
+
+// Run runs all the conformance tests, in a subtest named after the header
+// path of the codec, each test being a subtest of its own. Several codecs can
+// then be tested by the same test function.
+func Run(t *testing.T, cfg Config) {
+	if cfg.Nodes == nil {
+		cfg.Nodes = Nodes
+	}
+
+	checks := []check{
+		{"Header", HeaderTest},
+		{"RoundTrip", RoundTripTest},
+		{"Canonical", CanonicalTest},
+		{"Links", LinksTest},
+	}
+	if !cfg.Unterminated {
+		checks = append(checks, check{"Truncated", TruncatedTest})
+	}
+	if cfg.FixturesDir != "" {
+		checks = append(checks, check{"Fixtures", FixturesTest})
+	}
+
+	name := strings.TrimPrefix(string(mc.HeaderPath(cfg.Codec.Header())), "/")
+	t.Run(name, func(t *testing.T) {
+		for _, c := range checks {
+			c := c
+			t.Run(c.name, func(t *testing.T) {
+				c.run(t, cfg)
+			})
+		}
+	})
+}
+
+// HeaderTest checks that encoded nodes start with the codec header, and that
+// the codec refuses to decode objects with another header.
+func HeaderTest(t *testing.T, cfg Config) {
+	hdr := cfg.Codec.Header()
+	other := mc.Header([]byte("/codectest/other"))
+
+	for _, name := range names(cfg) {
+		encoded, ok := encode(t, cfg, name)
+		if !ok {
+			continue
+		}
+
+		if !bytes.HasPrefix(encoded, hdr) {
+			t.Errorf("%s: encoded node does not start with header %q", name, hdr)
+			continue
+		}
+
+		wrong := append(append([]byte(nil), other...), encoded[len(hdr):]...)
+		var n ipld.Node
+		if err := decode(cfg, wrong, &n); err == nil && !isRaw(cfg, n, wrong) {
+			t.Errorf("%s: decoded a node with header %q", name, other)
+		}
+	}
+}
+
+// RoundTripTest checks that decoding an encoded node and encoding it again
+// gives the same bytes.
+func RoundTripTest(t *testing.T, cfg Config) {
+	for _, name := range names(cfg) {
+		encoded, ok := encode(t, cfg, name)
+		if !ok {
+			continue
+		}
+
+		var n ipld.Node
+		if err := decode(cfg, encoded, &n); err != nil {
+			t.Errorf("%s: could not decode: %s", name, err)
+			continue
+		}
+
+		encoded2, err := mc.Marshal(cfg.Codec, &n)
+		if err != nil {
+			t.Errorf("%s: could not encode decoded node: %s", name, err)
+			continue
+		}
+		if !bytes.Equal(encoded, encoded2) {
+			t.Errorf("%s: round trip changed the encoding", name)
+			t.Logf("before: %q", encoded)
+			t.Logf("after:  %q", encoded2)
+		}
+	}
+}
+
+// CanonicalTest checks that equal nodes always have the same encoding, as
+// their hash would differ otherwise.
+func CanonicalTest(t *testing.T, cfg Config) {
+	for _, name := range names(cfg) {
+		encoded, ok := encode(t, cfg, name)
+		if !ok {
+			continue
+		}
+
+		for i := 0; i < 10; i++ {
+			n := copyNode(cfg.Nodes[name])
+			encoded2, err := mc.Marshal(cfg.Codec, &n)
+			if err != nil {
+				t.Errorf("%s: could not encode: %s", name, err)
+				break
+			}
+			if !bytes.Equal(encoded, encoded2) {
+				t.Errorf("%s: equal nodes have different encodings", name)
+				t.Logf("first:  %q", encoded)
+				t.Logf("second: %q", encoded2)
+				break
+			}
+		}
+	}
+}
+
+// LinksTest checks that the links of the nodes are preserved.
+func LinksTest(t *testing.T, cfg Config) {
+	for _, name := range names(cfg) {
+		encoded, ok := encode(t, cfg, name)
+		if !ok {
+			continue
+		}
+
+		var n ipld.Node
+		if err := decode(cfg, encoded, &n); err != nil {
+			t.Errorf("%s: could not decode: %s", name, err)
+			continue
+		}
+
+		expected := ipld.Links(cfg.Nodes[name])
+		actual := ipld.Links(n)
+		if len(expected) != len(actual) {
+			t.Errorf("%s: expected %d links, got %d", name, len(expected), len(actual))
+		}
+		for p, l := range expected {
+			if actual[p].LinkStr() != l.LinkStr() {
+				t.Errorf("%s: link %s changed from %s to %s", name, p, l.LinkStr(), actual[p].LinkStr())
+			}
+		}
+	}
+}
+
+// TruncatedTest checks that the codec fails to decode truncated objects. The
+// truncated objects which still decode successfully, for instance because
+// only trailing whitespace was removed, must decode to the same node.
+func TruncatedTest(t *testing.T, cfg Config) {
+	for _, name := range names(cfg) {
+		encoded, ok := encode(t, cfg, name)
+		if !ok {
+			continue
+		}
+
+		for i := 0; i < len(encoded); i++ {
+			var n ipld.Node
+			if err := decode(cfg, encoded[:i], &n); err != nil {
+				continue
+			}
+			if i < len(cfg.Codec.Header()) && isRaw(cfg, n, encoded[:i]) {
+				continue
+			}
+
+			encoded2, err := mc.Marshal(cfg.Codec, &n)
+			if err != nil || !bytes.Equal(encoded, encoded2) {
+				t.Errorf("%s: decoded a different node from the first %d bytes", name, i)
+				break
+			}
+		}
+	}
+}
+
+// FixturesTest checks the encoded nodes against the fixtures in
+// cfg.FixturesDir, or writes them there with the -codectest.update flag.
+func FixturesTest(t *testing.T, cfg Config) {
+	for _, name := range names(cfg) {
+		encoded, ok := encode(t, cfg, name)
+		if !ok {
+			continue
+		}
+
+		fname := FixturePath(cfg, name)
+		if *update {
+			if err := os.MkdirAll(cfg.FixturesDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(fname, encoded, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		fixture, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Errorf("%s: %s. please run: go test -codectest.update", name, err)
+			continue
+		}
+		if !bytes.Equal(fixture, encoded) {
+			t.Errorf("%s: encoding differs from %s", name, fname)
+			continue
+		}
+
+		var n ipld.Node
+		if err := decode(cfg, fixture, &n); err != nil {
+			t.Errorf("%s: could not decode %s: %s", name, fname, err)
+		}
+	}
+}
+
+// FixturePath returns the path of the fixture holding the named node.
+func FixturePath(cfg Config, name string) string {
+	codec := string(mc.HeaderPath(cfg.Codec.Header()))
+	codec = strings.Replace(strings.Trim(codec, "/"), "/", "-", -1)
+	return filepath.Join(cfg.FixturesDir, name+"."+codec)
+}
+
+// names returns the names of the nodes under test, sorted.
+func names(cfg Config) []string {
+	var res []string
+	for name := range cfg.Nodes {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func encode(t *testing.T, cfg Config, name string) ([]byte, bool) {
+	n := cfg.Nodes[name]
+	encoded, err := mc.Marshal(cfg.Codec, &n)
+	if err != nil {
+		t.Errorf("%s: could not encode: %s", name, err)
+		return nil, false
+	}
+	return encoded, true
+}
+
+// decode decodes the object, turning panics into errors.
+func decode(cfg Config, encoded []byte, n *ipld.Node) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return mc.Unmarshal(cfg.Codec, encoded, n)
+}
+
+// isRaw returns whether n is the raw node expected from decoding the object
+// encoded, when cfg.RawFallback is set.
+func isRaw(cfg Config, n ipld.Node, encoded []byte) bool {
+	data, ok := n.RawData()
+	return cfg.RawFallback && ok && bytes.Equal(data, encoded)
+}
+
+// copyNode returns a deep copy of n, with maps filled in another order.
+func copyNode(n ipld.Node) ipld.Node {
+	return copyValue(map[string]interface{}(n)).(ipld.Node)
+}
+
+func copyValue(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case ipld.Node:
+		return copyValue(map[string]interface{}(vv))
+	case map[string]interface{}:
+		keys := make([]string, 0, len(vv))
+		for k := range vv {
+			keys = append(keys, k)
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+
+		res := ipld.Node{}
+		for _, k := range keys {
+			res[k] = copyValue(vv[k])
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(vv))
+		for i, c := range vv {
+			res[i] = copyValue(c)
+		}
+		return res
+	case []ipld.Node:
+		res := make([]ipld.Node, len(vv))
+		for i, c := range vv {
+			res[i] = copyNode(c)
+		}
+		return res
+	case []byte:
+		if vv == nil {
+			return vv
+		}
+		res := make([]byte, len(vv))
+		copy(res, vv)
+		return res
+	}
+	return v
+}
@@ -1,11 +1,17 @@
 package ipfsld
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
 	mc "github.com/jbenet/go-multicodec"
 	mccbor "github.com/jbenet/go-multicodec/cbor"
 	mcmux "github.com/jbenet/go-multicodec/mux"
 
 	ipld "github.com/ipfs/go-ipld"
+	git "github.com/ipfs/go-ipld/coding/git"
 	pb "github.com/ipfs/go-ipld/coding/pb"
 )
 
@@ -16,16 +22,25 @@ import (
 // that the hashes must be the same.
 var defaultCodec string
 
-var muxCodec *mcmux.Multicodec
+var muxCodec *codecMux
 
 func init() {
 	// by default, always encode things as cbor
 	defaultCodec = string(mc.HeaderPath(mccbor.Header))
-	muxCodec = mcmux.MuxMulticodec([]mc.Multicodec{
+	muxCodec = newCodecMux(nil)
+	for _, c := range []mc.Multicodec{
 		CborMulticodec(),
 		JsonMulticodec(),
+		YamlMulticodec(),
+		MsgpackMulticodec(),
 		pb.Multicodec(),
-	}, selectCodec)
+		git.Multicodec(),
+		RawMulticodec(),
+	} {
+		if err := RegisterCodec(c); err != nil {
+			panic(err)
+		}
+	}
 }
 
 // Multicodec returns a muxing codec that marshals to
@@ -41,18 +56,31 @@ func selectCodec(v interface{}, codecs []mc.Multicodec) mc.Multicodec {
 		return nil
 	}
 
-	codecKey, err := codecKey(*vn)
-	if err != nil {
-		return nil
-	}
+	c, _ := selectNodeCodec(*vn, codecs)
+	return c
+}
 
-	for _, c := range codecs {
-		if codecKey == string(mc.HeaderPath(c.Header())) {
-			return c
+// selectNodeCodec returns the codec among codecs that n must be encoded with,
+// or a *CodecError if there is none.
+func selectNodeCodec(n ipld.Node, codecs []mc.Multicodec) (mc.Multicodec, error) {
+	codecKey, err := codecKey(n)
+	if err == nil {
+		for _, c := range codecs {
+			if codecKey == string(mc.HeaderPath(c.Header())) {
+				return c, nil
+			}
 		}
 	}
 
-	return nil // no codec
+	available := make([]string, len(codecs))
+	for i, c := range codecs {
+		available[i] = string(mc.HeaderPath(c.Header()))
+	}
+	codec, ok := n[ipld.CodecKey]
+	if !ok {
+		codec = codecKey
+	}
+	return nil, &CodecError{Codec: codec, Available: available}
 }
 
 func codecKey(n ipld.Node) (string, error) {
@@ -61,7 +89,7 @@ func codecKey(n ipld.Node) (string, error) {
 		// if no codec is defined, use our default codec
 		chdr = defaultCodec
 		if pb.IsOldProtobufNode(n) {
-			chdr = string(pb.Header)
+			chdr = string(mc.HeaderPath(pb.Header))
 		}
 	}
 
@@ -73,3 +101,134 @@ func codecKey(n ipld.Node) (string, error) {
 
 	return chdrs, nil
 }
+
+// CodecError is returned when encoding a node whose @codec does not name any
+// of the available codecs.
+type CodecError struct {
+	Codec     interface{} // the requested codec, usually the @codec value
+	Available []string    // header paths of the available codecs
+}
+
+func (e *CodecError) Error() string {
+	available := strings.Join(e.Available, ", ")
+	if s, ok := e.Codec.(string); ok {
+		return fmt.Sprintf("unknown codec %q (available: %s)", s, available)
+	}
+	return fmt.Sprintf("invalid %s %#v: not a string (available: %s)", ipld.CodecKey, e.Codec, available)
+}
+
+// codecMux is the mux codec over the IPLD codecs. Unlike a plain mux, its
+// encoders tell why no codec could be selected for a node, and raw nodes are
+// encoded as their content alone, see RawMulticodec.
+type codecMux struct {
+	codecs []mc.Multicodec // the muxed codecs, or nil for the registered ones
+}
+
+func newCodecMux(codecs []mc.Multicodec) *codecMux {
+	return &codecMux{codecs}
+}
+
+// mux returns a plain mux over the codecs of c. The registered codecs are
+// read when it is called, so that encoders and decoders keep using the same
+// ones even if codecs are registered meanwhile.
+func (c *codecMux) mux() *mcmux.Multicodec {
+	codecs := c.codecs
+	if codecs == nil {
+		codecs = List()
+	}
+	return mcmux.MuxMulticodec(codecs, selectCodec)
+}
+
+func (c *codecMux) Header() []byte {
+	return mcmux.Header
+}
+
+func (c *codecMux) Encoder(w io.Writer) mc.Encoder {
+	m := c.mux()
+	return &codecMuxEncoder{m.Encoder(w), w, m.Codecs}
+}
+
+func (c *codecMux) Decoder(r io.Reader) mc.Decoder {
+	return &codecMuxDecoder{r, c}
+}
+
+// findRawCodec returns the raw codec among codecs, or nil.
+func findRawCodec(codecs []mc.Multicodec) mc.Multicodec {
+	for _, sub := range codecs {
+		if isRawCodec(sub) {
+			return sub
+		}
+	}
+	return nil
+}
+
+type codecMuxEncoder struct {
+	mc.Encoder
+	w      io.Writer
+	codecs []mc.Multicodec
+}
+
+func (e *codecMuxEncoder) Encode(v interface{}) error {
+	if vn, ok := v.(*ipld.Node); ok {
+		sub, err := selectNodeCodec(*vn, e.codecs)
+		if err != nil {
+			return err
+		}
+		// raw content which would be ambiguous is muxed, see RawMulticodec
+		if data, ok := vn.RawData(); isRawCodec(sub) && (!ok || !isAmbiguousRaw(data)) {
+			return sub.Encoder(e.w).Encode(v)
+		}
+	}
+	return e.Encoder.Encode(v)
+}
+
+type codecMuxDecoder struct {
+	r io.Reader
+	c *codecMux
+}
+
+func (d *codecMuxDecoder) Decode(v interface{}) error {
+	// read no more than the header, so that nothing past the object is read.
+	hdr := make([]byte, 1, 128)
+	n, err := io.ReadFull(d.r, hdr)
+	if n == 1 && hdr[0] > 0 && hdr[0] < 128 {
+		hdr = hdr[:1+int(hdr[0])]
+		n, err = io.ReadFull(d.r, hdr[1:])
+		n++
+	}
+	if n == 0 || err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	hdr = hdr[:n]
+	r := io.MultiReader(bytes.NewReader(hdr), d.r)
+
+	// objects without any multicodec header are raw nodes, the others must
+	// have our header.
+	if d.c.isTruncatedHeader(hdr) {
+		return io.ErrUnexpectedEOF
+	}
+	m := d.c.mux()
+	if !hasMulticodecHeader(hdr) {
+		if raw := findRawCodec(m.Codecs); raw != nil {
+			return raw.Decoder(r).Decode(v)
+		}
+	}
+	return m.Decoder(r).Decode(v)
+}
+
+// isTruncatedHeader returns whether data is the start of the mux header, cut
+// short.
+func (c *codecMux) isTruncatedHeader(data []byte) bool {
+	hdr := c.Header()
+	return len(data) >= 2 && len(data) < len(hdr) && bytes.HasPrefix(hdr, data)
+}
+
+// hasMulticodecHeader returns whether data starts with a multicodec header:
+// a length byte, followed by as many bytes, the last one being a newline.
+func hasMulticodecHeader(data []byte) bool {
+	if len(data) == 0 || data[0] == 0 || data[0] >= 128 {
+		return false
+	}
+	l := int(data[0])
+	return len(data) > l && data[l] == '\n'
+}
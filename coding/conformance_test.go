@@ -0,0 +1,35 @@
+package ipfsld
+
+import (
+	"testing"
+
+	codectest "github.com/ipfs/go-ipld/coding/codectest"
+)
+
+func TestConformance(t *testing.T) {
+	codectest.Run(t, codectest.Config{
+		Codec:       CborMulticodec(),
+		FixturesDir: "testdata",
+	})
+	codectest.Run(t, codectest.Config{
+		Codec:       JsonMulticodec(),
+		FixturesDir: "testdata",
+	})
+	codectest.Run(t, codectest.Config{
+		Codec:        YamlMulticodec(),
+		FixturesDir:  "testdata",
+		Unterminated: true,
+	})
+	codectest.Run(t, codectest.Config{
+		Codec:       MsgpackMulticodec(),
+		FixturesDir: "testdata",
+	})
+	codectest.Run(t, codectest.Config{
+		Codec: PrettyJsonMulticodec("\t"),
+	})
+	codectest.Run(t, codectest.Config{
+		Codec:       Multicodec(),
+		FixturesDir: "testdata",
+		RawFallback: true,
+	})
+}
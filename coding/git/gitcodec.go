@@ -0,0 +1,602 @@
+// Package ipldgit is a codec for git objects. Objects are encoded in the form
+// git hashes, "<type> <size>\x00<content>"; a loose object file is this
+// encoding zlib-compressed, without the codec header.
+//
+// Git objects are decoded to the following nodes, all of them with their
+// @codec set to "/git" and their @type to the type of the object:
+//
+//	blob:   { "data": <bytes> }
+//	tree:   { "<name>": { "mlink": <hash>, "mode": "100644" }, ... }
+//	commit: { "tree": <link>, "parents": [ <link>, ... ],
+//	          "author": "...", "committer": "...", "message": "..." }
+//	tag:    { "object": <link>, "type": "commit", "tag": "v1.0",
+//	          "tagger": "...", "message": "..." }
+//
+// Tree entry names are escaped with ipld.EscapePathComponent. The links are
+// the SHA-1 multihashes of the objects, as returned by Hash. The commit and
+// tag headers not listed above, like "gpgsig", are kept in order in "extra",
+// as a list of { "name": "...", "value": "..." } nodes.
+//
+// The links are git object ids, not the keys under which the store package
+// keeps blocks, which are the hashes of the blocks with their codec headers.
+// The store cannot follow them: git objects reachable only through the links
+// of other git objects are collected by store.GC, and store.Transform,
+// store.Export or store.CumulativeSize fail to find them.
+package ipldgit
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	mc "github.com/jbenet/go-multicodec"
+	mh "github.com/jbenet/go-multihash"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+var Header []byte
+
+// maxObjectSize bounds the size of the objects read by the decoder, so that
+// a corrupted size cannot make it allocate arbitrary amounts of memory.
+const maxObjectSize = 1 << 30
+
+var (
+	errInvalidObject = errors.New("invalid git object")
+	errInvalidLink   = errors.New("invalid git object, link is not a git SHA-1 multihash")
+)
+
+func init() {
+	Header = mc.Header([]byte("/git"))
+}
+
+type codec struct{}
+
+func Multicodec() mc.Multicodec {
+	return &codec{}
+}
+
+func (c *codec) Encoder(w io.Writer) mc.Encoder {
+	return &encoder{w: w, c: c}
+}
+
+func (c *codec) Decoder(r io.Reader) mc.Decoder {
+	return &decoder{r: r, c: c}
+}
+
+func (c *codec) Header() []byte {
+	return Header
+}
+
+type encoder struct {
+	w io.Writer
+	c *codec
+}
+
+type decoder struct {
+	r io.Reader
+	c *codec
+}
+
+func (c *encoder) Encode(v interface{}) error {
+	nv, ok := v.(*ipld.Node)
+	if !ok {
+		return errors.New("must encode *ipld.Node")
+	}
+
+	obj, err := ld2gitObject(*nv)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.w.Write(c.c.Header()); err != nil {
+		return err
+	}
+	_, err = c.w.Write(obj)
+	return err
+}
+
+func (c *decoder) Decode(v interface{}) error {
+	nv, ok := v.(*ipld.Node)
+	if !ok {
+		return errors.New("must decode to *ipld.Node")
+	}
+
+	if err := mc.ConsumeHeader(c.r, c.c.Header()); err != nil {
+		return err
+	}
+
+	typ, content, err := readObject(c.r)
+	if err != nil {
+		return err
+	}
+
+	n, err := git2ldNode(typ, content)
+	if err != nil {
+		return err
+	}
+	*nv = n
+	return nil
+}
+
+// Hash returns the SHA-1 multihash of the git object n, which is what links
+// to it are made of.
+func Hash(n ipld.Node) (mh.Multihash, error) {
+	obj, err := ld2gitObject(n)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum(obj)
+	return mh.Encode(sum[:], mh.SHA1)
+}
+
+// readObject reads an object, without reading past its end.
+func readObject(r io.Reader) (typ string, content []byte, err error) {
+	var hdr []byte
+	var b [1]byte
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return "", nil, err
+		}
+		if b[0] == 0 {
+			break
+		}
+		hdr = append(hdr, b[0])
+		if len(hdr) > 32 {
+			return "", nil, errInvalidObject
+		}
+	}
+
+	fields := strings.Split(string(hdr), " ")
+	if len(fields) != 2 {
+		return "", nil, errInvalidObject
+	}
+	size, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil || strconv.FormatUint(size, 10) != fields[1] || size > maxObjectSize {
+		return "", nil, errInvalidObject
+	}
+
+	content = make([]byte, size)
+	if _, err := io.ReadFull(r, content); err == io.EOF {
+		return "", nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return "", nil, err
+	}
+	return fields[0], content, nil
+}
+
+func git2ldNode(typ string, content []byte) (ipld.Node, error) {
+	var n ipld.Node
+	var err error
+	switch typ {
+	case "blob":
+		n = ipld.Node{"data": content}
+	case "tree":
+		n, err = git2ldTree(content)
+	case "commit":
+		n, err = git2ldCommit(content)
+	case "tag":
+		n, err = git2ldTag(content)
+	default:
+		err = fmt.Errorf("%s, unknown type %q", errInvalidObject, typ)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	n[ipld.CodecKey] = string(mc.HeaderPath(Header))
+	n[ipld.TypeKey] = typ
+	return n, nil
+}
+
+func ld2gitObject(n ipld.Node) ([]byte, error) {
+	var content []byte
+	var err error
+	typ := n.Type()
+	switch typ {
+	case "blob":
+		var ok bool
+		content, ok = n["data"].([]byte)
+		if !ok {
+			err = fmt.Errorf("%s, blob data not bytes", errInvalidObject)
+		}
+	case "tree":
+		content, err = ld2gitTree(n)
+	case "commit":
+		content, err = ld2gitCommit(n)
+	case "tag":
+		content, err = ld2gitTag(n)
+	default:
+		err = fmt.Errorf("%s, unknown type %q", errInvalidObject, typ)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	obj := []byte(fmt.Sprintf("%s %d\x00", typ, len(content)))
+	return append(obj, content...), nil
+}
+
+// treeEntry is an entry of a tree object: "<mode> <name>\x00<sha1>".
+type treeEntry struct {
+	mode string
+	name string
+	hash []byte
+}
+
+// sortName returns the name git sorts the entry by: trees sort as if their
+// name ended with a "/".
+func (e *treeEntry) sortName() string {
+	if e.mode == "40000" {
+		return e.name + "/"
+	}
+	return e.name
+}
+
+func git2ldTree(content []byte) (ipld.Node, error) {
+	n := ipld.Node{}
+	for len(content) > 0 {
+		i := bytes.IndexByte(content, ' ')
+		j := bytes.IndexByte(content, 0)
+		if i < 0 || j < i || len(content) < j+1+sha1.Size {
+			return nil, fmt.Errorf("%s, truncated tree entry", errInvalidObject)
+		}
+
+		e := &treeEntry{
+			mode: string(content[:i]),
+			name: string(content[i+1 : j]),
+			hash: content[j+1 : j+1+sha1.Size],
+		}
+		content = content[j+1+sha1.Size:]
+
+		if err := checkTreeEntry(e); err != nil {
+			return nil, err
+		}
+		key := ipld.EscapePathComponent(e.name)
+		if _, ok := n[key]; ok {
+			return nil, fmt.Errorf("%s, duplicate tree entry %q", errInvalidObject, e.name)
+		}
+
+		link, err := gitLink(e.hash)
+		if err != nil {
+			return nil, err
+		}
+		link["mode"] = e.mode
+		n[key] = link
+	}
+	return n, nil
+}
+
+func ld2gitTree(n ipld.Node) ([]byte, error) {
+	var entries []*treeEntry
+	for k, v := range n {
+		if k == ipld.CodecKey || k == ipld.TypeKey {
+			continue
+		}
+
+		link, ok := ipld.LinkCast(v)
+		if !ok {
+			return nil, fmt.Errorf("%s, tree entry %q is not a link", errInvalidObject, k)
+		}
+		hash, err := gitHash(link)
+		if err != nil {
+			return nil, err
+		}
+		mode, _ := link["mode"].(string)
+
+		e := &treeEntry{mode: mode, name: ipld.UnescapePathComponent(k), hash: hash}
+		if err := checkTreeEntry(e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	sort.Sort(byGitOrder(entries))
+
+	var buf bytes.Buffer
+	for _, e := range entries {
+		buf.WriteString(e.mode)
+		buf.WriteByte(' ')
+		buf.WriteString(e.name)
+		buf.WriteByte(0)
+		buf.Write(e.hash)
+	}
+	return buf.Bytes(), nil
+}
+
+func checkTreeEntry(e *treeEntry) error {
+	if e.name == "" || e.name == "." || e.name == ".." || strings.ContainsAny(e.name, "/\x00") {
+		return fmt.Errorf("%s, invalid tree entry name %q", errInvalidObject, e.name)
+	}
+	if _, err := strconv.ParseUint(e.mode, 8, 32); err != nil || e.mode[0] == '0' {
+		return fmt.Errorf("%s, invalid mode %q for tree entry %q", errInvalidObject, e.mode, e.name)
+	}
+	return nil
+}
+
+type byGitOrder []*treeEntry
+
+func (s byGitOrder) Len() int           { return len(s) }
+func (s byGitOrder) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byGitOrder) Less(i, j int) bool { return s[i].sortName() < s[j].sortName() }
+
+// header is a header line of a commit or tag. Continuation lines are joined
+// to the value with "\n".
+type header struct {
+	name  string
+	value string
+}
+
+// parseHeaders splits a commit or tag into its headers and its message.
+func parseHeaders(content []byte) ([]header, string, error) {
+	var headers []header
+	s := string(content)
+	for {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			return nil, "", fmt.Errorf("%s, no message", errInvalidObject)
+		}
+		line := s[:i]
+		s = s[i+1:]
+
+		switch {
+		case line == "":
+			return headers, s, nil
+		case line[0] == ' ':
+			if len(headers) == 0 {
+				return nil, "", fmt.Errorf("%s, invalid header", errInvalidObject)
+			}
+			headers[len(headers)-1].value += "\n" + line[1:]
+		default:
+			j := strings.IndexByte(line, ' ')
+			if j <= 0 {
+				return nil, "", fmt.Errorf("%s, invalid header %q", errInvalidObject, line)
+			}
+			headers = append(headers, header{line[:j], line[j+1:]})
+		}
+	}
+}
+
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	buf.WriteByte(' ')
+	buf.WriteString(strings.Replace(value, "\n", "\n ", -1))
+	buf.WriteByte('\n')
+}
+
+func git2ldCommit(content []byte) (ipld.Node, error) {
+	headers, message, err := parseHeaders(content)
+	if err != nil {
+		return nil, err
+	}
+
+	n := ipld.Node{"message": message}
+	if len(headers) == 0 || headers[0].name != "tree" {
+		return nil, fmt.Errorf("%s, commit has no tree", errInvalidObject)
+	}
+	if n["tree"], err = hexLink(headers[0].value); err != nil {
+		return nil, err
+	}
+	headers = headers[1:]
+
+	parents := []interface{}{}
+	for len(headers) > 0 && headers[0].name == "parent" {
+		link, err := hexLink(headers[0].value)
+		if err != nil {
+			return nil, err
+		}
+		parents = append(parents, link)
+		headers = headers[1:]
+	}
+	n["parents"] = parents
+
+	for _, name := range []string{"author", "committer"} {
+		if len(headers) == 0 || headers[0].name != name {
+			return nil, fmt.Errorf("%s, commit has no %s", errInvalidObject, name)
+		}
+		n[name] = headers[0].value
+		headers = headers[1:]
+	}
+
+	if len(headers) > 0 {
+		n["extra"] = extraNodes(headers)
+	}
+	return n, nil
+}
+
+func ld2gitCommit(n ipld.Node) ([]byte, error) {
+	var buf bytes.Buffer
+
+	tree, err := hexHash(n["tree"])
+	if err != nil {
+		return nil, err
+	}
+	writeHeader(&buf, "tree", tree)
+
+	if v, ok := n["parents"]; ok {
+		parents, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s, commit parents not a list", errInvalidObject)
+		}
+		for _, p := range parents {
+			parent, err := hexHash(p)
+			if err != nil {
+				return nil, err
+			}
+			writeHeader(&buf, "parent", parent)
+		}
+	}
+
+	for _, name := range []string{"author", "committer"} {
+		if err := writeStringHeader(&buf, n, name, true); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writeTrailer(&buf, n); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func git2ldTag(content []byte) (ipld.Node, error) {
+	headers, message, err := parseHeaders(content)
+	if err != nil {
+		return nil, err
+	}
+
+	n := ipld.Node{"message": message}
+	if len(headers) == 0 || headers[0].name != "object" {
+		return nil, fmt.Errorf("%s, tag has no object", errInvalidObject)
+	}
+	if n["object"], err = hexLink(headers[0].value); err != nil {
+		return nil, err
+	}
+	headers = headers[1:]
+
+	for _, name := range []string{"type", "tag"} {
+		if len(headers) == 0 || headers[0].name != name {
+			return nil, fmt.Errorf("%s, tag has no %s", errInvalidObject, name)
+		}
+		n[name] = headers[0].value
+		headers = headers[1:]
+	}
+
+	// old tags have no tagger
+	if len(headers) > 0 && headers[0].name == "tagger" {
+		n["tagger"] = headers[0].value
+		headers = headers[1:]
+	}
+
+	if len(headers) > 0 {
+		n["extra"] = extraNodes(headers)
+	}
+	return n, nil
+}
+
+func ld2gitTag(n ipld.Node) ([]byte, error) {
+	var buf bytes.Buffer
+
+	object, err := hexHash(n["object"])
+	if err != nil {
+		return nil, err
+	}
+	writeHeader(&buf, "object", object)
+
+	for _, name := range []string{"type", "tag"} {
+		if err := writeStringHeader(&buf, n, name, true); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeStringHeader(&buf, n, "tagger", false); err != nil {
+		return nil, err
+	}
+
+	if err := writeTrailer(&buf, n); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeStringHeader writes the header holding the string n[name].
+func writeStringHeader(buf *bytes.Buffer, n ipld.Node, name string, required bool) error {
+	v, ok := n[name]
+	if !ok && !required {
+		return nil
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("%s, %s not a string", errInvalidObject, name)
+	}
+	writeHeader(buf, name, s)
+	return nil
+}
+
+// writeTrailer writes the extra headers and the message of a commit or tag.
+func writeTrailer(buf *bytes.Buffer, n ipld.Node) error {
+	if v, ok := n["extra"]; ok {
+		extra, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s, extra headers not a list", errInvalidObject)
+		}
+		for _, e := range extra {
+			en, _ := e.(ipld.Node)
+			name, ok1 := en["name"].(string)
+			value, ok2 := en["value"].(string)
+			if !ok1 || !ok2 || name == "" || strings.ContainsAny(name, " \n") {
+				return fmt.Errorf("%s, invalid extra header", errInvalidObject)
+			}
+			writeHeader(buf, name, value)
+		}
+	}
+
+	message, ok := n["message"].(string)
+	if !ok {
+		return fmt.Errorf("%s, message not a string", errInvalidObject)
+	}
+	buf.WriteByte('\n')
+	buf.WriteString(message)
+	return nil
+}
+
+func extraNodes(headers []header) []interface{} {
+	extra := make([]interface{}, len(headers))
+	for i, h := range headers {
+		extra[i] = ipld.Node{"name": h.name, "value": h.value}
+	}
+	return extra
+}
+
+// gitLink returns a link to the object with the given SHA-1 hash.
+func gitLink(hash []byte) (ipld.Node, error) {
+	h, err := mh.Encode(hash, mh.SHA1)
+	if err != nil {
+		return nil, err
+	}
+	return ipld.Node{ipld.LinkKey: mh.Multihash(h).B58String()}, nil
+}
+
+// hexLink returns a link to the object with the given hex SHA-1 hash.
+func hexLink(s string) (ipld.Node, error) {
+	if len(s) != 2*sha1.Size || strings.ToLower(s) != s {
+		return nil, fmt.Errorf("%s, invalid hash %q", errInvalidObject, s)
+	}
+	hash, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s, invalid hash %q", errInvalidObject, s)
+	}
+	return gitLink(hash)
+}
+
+// gitHash returns the SHA-1 hash a link points to.
+func gitHash(link ipld.Link) ([]byte, error) {
+	h, err := link.Hash()
+	if err != nil {
+		return nil, errInvalidLink
+	}
+	dh, err := mh.Decode(h)
+	if err != nil || dh.Code != mh.SHA1 || len(dh.Digest) != sha1.Size {
+		return nil, errInvalidLink
+	}
+	return dh.Digest, nil
+}
+
+// hexHash returns the hex SHA-1 hash the link v points to.
+func hexHash(v interface{}) (string, error) {
+	link, ok := ipld.LinkCast(v)
+	if !ok {
+		return "", errInvalidLink
+	}
+	hash, err := gitHash(link)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash), nil
+}
@@ -0,0 +1,191 @@
+package ipldgit
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	mc "github.com/jbenet/go-multicodec"
+	mh "github.com/jbenet/go-multihash"
+
+	ipld "github.com/ipfs/go-ipld"
+	codectest "github.com/ipfs/go-ipld/coding/codectest"
+)
+
+// testdata holds the objects of a small git repository, named by their id.
+// The tag points to the last commit, and the commit signed by Bob has extra
+// headers.
+const (
+	tagID    = "2672188d2d60ff88c5abd9b7f0e5641f3d62e700"
+	signedID = "651691226ad05309283afb2eebb7de2421eb3235"
+	headID   = "ef7778a3ee2a403a61dd9fab5e073e7708582980"
+	treeID   = "efa82dc8594af3807af4af2ed188adbac65d6051"
+)
+
+// readObjects reads the objects of testdata, keyed by their id.
+func readObjects(t *testing.T) map[string][]byte {
+	files, err := filepath.Glob("testdata/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects := map[string][]byte{}
+	for _, f := range files {
+		obj, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		objects[filepath.Base(f)] = obj
+	}
+	return objects
+}
+
+func decodeObject(t *testing.T, obj []byte) ipld.Node {
+	var n ipld.Node
+	if err := mc.Unmarshal(Multicodec(), append(Header, obj...), &n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func linkID(t *testing.T, l ipld.Link) string {
+	h, err := gitHash(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(h)
+}
+
+func TestObjects(t *testing.T) {
+	for id, obj := range readObjects(t) {
+		n := decodeObject(t, obj)
+
+		encoded, err := mc.Marshal(Multicodec(), &n)
+		if err != nil {
+			t.Errorf("%s: %s", id, err)
+			continue
+		}
+		if !bytes.Equal(encoded, append(Header, obj...)) {
+			t.Errorf("%s: round trip changed the object", id)
+			t.Logf("before: %q", obj)
+			t.Logf("after:  %q", encoded[len(Header):])
+		}
+
+		h, err := Hash(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dh, _ := mh.Decode(h)
+		if dh.Code != mh.SHA1 || hex.EncodeToString(dh.Digest) != id {
+			t.Errorf("%s: hashed as %s", id, hex.EncodeToString(dh.Digest))
+		}
+	}
+}
+
+func TestLinks(t *testing.T) {
+	objects := readObjects(t)
+
+	signed := decodeObject(t, objects[signedID])
+	links := signed.Links()
+	if len(links) != 2 || linkID(t, links["tree"]) != treeID || linkID(t, links["parents/0"]) != headID {
+		t.Errorf("unexpected commit links: %v", links)
+	}
+	if signed["author"] != "Bob <bob@example.com> 1445000100 +0000" {
+		t.Errorf("unexpected author: %v", signed["author"])
+	}
+	extra := signed["extra"].([]interface{})
+	if len(extra) != 2 || extra[1].(ipld.Node)["value"] != "-----BEGIN PGP SIGNATURE-----\n\niQEcBAABAgAGBQJWIc\n-----END PGP SIGNATURE-----" {
+		t.Errorf("unexpected extra headers: %v", extra)
+	}
+
+	tree := decodeObject(t, objects[treeID])
+	var paths []string
+	for p, l := range tree.Links() {
+		paths = append(paths, p+" "+l["mode"].(string))
+	}
+	sort.Strings(paths)
+	expected := []string{"@at 100644", "dir 40000", "dir.txt 100644", "hello.txt 100644"}
+	if len(paths) != len(expected) {
+		t.Fatalf("unexpected tree links: %v", paths)
+	}
+	for i := range expected {
+		if paths[i] != expected[i] {
+			t.Errorf("unexpected tree links: %v", paths)
+			break
+		}
+	}
+
+	// the whole history is reachable from the tag
+	seen := map[string]bool{}
+	var visit func(id string)
+	visit = func(id string) {
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+
+		obj, ok := objects[id]
+		if !ok {
+			t.Errorf("missing object %s", id)
+			return
+		}
+		for _, l := range decodeObject(t, obj).Links() {
+			visit(linkID(t, l))
+		}
+	}
+	visit(tagID)
+	if len(seen) != len(objects)-1 { // all but the signed commit
+		t.Errorf("reached %d objects out of %d", len(seen), len(objects)-1)
+	}
+}
+
+func TestInvalidObjects(t *testing.T) {
+	for _, obj := range []string{
+		"blob 3\x00ab",
+		"blob 03\x00abc",
+		"blob\x00abc",
+		"file 3\x00abc",
+		"tree 5\x00100644",
+		"commit 13\x00tree abcdef\n\n",
+		"commit 18\x00author Bob <bob>\n\n",
+		"tag 14\x00type commit\n\n",
+	} {
+		var n ipld.Node
+		if err := mc.Unmarshal(Multicodec(), append(Header, obj...), &n); err == nil {
+			t.Errorf("decoded invalid object %q", obj)
+		}
+	}
+
+	for _, n := range []ipld.Node{
+		{ipld.TypeKey: "file"},
+		{ipld.TypeKey: "blob", "data": "not bytes"},
+		{ipld.TypeKey: "tree", "foo": "not a link"},
+		{ipld.TypeKey: "tree", "foo": ipld.Node{ipld.LinkKey: "QmZku7P7KeeHAnwMr6c4HveYfMzmtVinNXzibkiNbfDbPo", "mode": "100644"}},
+		{ipld.TypeKey: "commit", "message": "no tree"},
+	} {
+		if _, err := mc.Marshal(Multicodec(), &n); err == nil {
+			t.Errorf("encoded invalid object %#v", n)
+		}
+	}
+}
+
+func TestConformance(t *testing.T) {
+	objects := readObjects(t)
+	nodes := map[string]ipld.Node{}
+	for name, id := range map[string]string{
+		"blob":   "ce013625030ba8dba906f756967f9e9ca394464a",
+		"tree":   treeID,
+		"commit": signedID,
+		"tag":    tagID,
+	} {
+		nodes[name] = decodeObject(t, objects[id])
+	}
+
+	codectest.Run(t, codectest.Config{
+		Codec: Multicodec(),
+		Nodes: nodes,
+	})
+}
@@ -0,0 +1,422 @@
+package ipfsld
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+var (
+	errLazyCodec     = errors.New("lazy decoding needs a CBOR object")
+	errLazyRoot      = errors.New("lazy decoding needs a CBOR map at the root")
+	errLazyTruncated = errors.New("truncated CBOR object")
+	errLazyTrailing  = errors.New("unexpected data after the CBOR object")
+	errLazyInvalid   = errors.New("invalid CBOR item")
+)
+
+// LazyNode is a node backed by its CBOR encoding, which is only decoded when
+// accessed: reading one property of a large block does not build the whole
+// tree. Its properties are returned with the same types as the ones decoded
+// by CborMulticodec, except that maps are returned as *LazyNode and lists as
+// *LazyList. Byte strings alias the encoded object.
+//
+// Lazy nodes implement ipld.LazyValue, so ipld.GetPath descends into them:
+//
+//	n, err := DecodeLazy(block)
+//	name := n.Get("/author/name")
+type LazyNode struct {
+	data []byte // the encoded map
+}
+
+// LazyList is a list held by a LazyNode, only decoded when accessed.
+type LazyList struct {
+	data []byte // the encoded list
+}
+
+// DecodeLazy returns the node encoded by data, an object encoded by
+// CborMulticodec or by Multicodec. The object is checked to be well formed,
+// but nothing is decoded yet. The node aliases data, which must not be
+// modified while the node is used.
+func DecodeLazy(data []byte) (*LazyNode, error) {
+	data = bytes.TrimPrefix(data, muxCodec.Header())
+	header := CborMulticodec().Header()
+	if !bytes.HasPrefix(data, header) {
+		return nil, errLazyCodec
+	}
+	data = data[len(header):]
+
+	end, err := cborSkip(data, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	if end != len(data) {
+		return nil, errLazyTrailing
+	}
+	if cborMajor(data, 0) != cborMap {
+		return nil, errLazyRoot
+	}
+	return &LazyNode{data}, nil
+}
+
+// Get returns the descendant of n at path, like ipld.Node.Get.
+func (n *LazyNode) Get(path string) interface{} {
+	return ipld.GetPath(n, path)
+}
+
+// Child returns the property of n named key, escaped like in ipld.Node, or
+// nil if there is none. If there are several, the last one is returned, like
+// when decoding the whole node.
+func (n *LazyNode) Child(key string) interface{} {
+	key = ipld.EscapePathComponent(key)
+
+	var child interface{}
+	n.entries(func(k []byte, voff int) {
+		if string(k) == key {
+			child, _ = cborValue(n.data, voff)
+		}
+	})
+	return child
+}
+
+// Keys returns the keys of n, in their encoded order.
+func (n *LazyNode) Keys() []string {
+	var keys []string
+	n.entries(func(k []byte, _ int) {
+		keys = append(keys, string(k))
+	})
+	return keys
+}
+
+// Node decodes the whole node.
+func (n *LazyNode) Node() ipld.Node {
+	node := ipld.Node{}
+	n.entries(func(k []byte, voff int) {
+		v, _ := cborValue(n.data, voff)
+		node[string(k)] = materialize(v)
+	})
+	return node
+}
+
+// Bytes returns the CBOR encoding of n, without codec header.
+func (n *LazyNode) Bytes() []byte {
+	return n.data
+}
+
+// entries calls f with the key and the offset of the value of each entry of
+// n. The entries whose key is not a string are skipped, like when decoding
+// the whole node.
+func (n *LazyNode) entries(f func(k []byte, voff int)) {
+	cborItems(n.data, func(i, off int) {
+		if i%2 == 1 {
+			return
+		}
+		voff, _ := cborSkip(n.data, off, 1)
+		if k, ok := cborKey(n.data, off); ok {
+			f(k, voff)
+		}
+	})
+}
+
+// Child returns the item of l at the offset comp, or nil if there is none.
+func (l *LazyList) Child(comp string) interface{} {
+	i, err := strconv.Atoi(comp)
+	if err != nil {
+		return nil
+	}
+	return l.Index(i)
+}
+
+// Index returns the item of l at offset i, or nil if there is none.
+func (l *LazyList) Index(i int) interface{} {
+	var item interface{}
+	cborItems(l.data, func(j, off int) {
+		if j == i {
+			item, _ = cborValue(l.data, off)
+		}
+	})
+	return item
+}
+
+// Len returns the number of items of l.
+func (l *LazyList) Len() int {
+	n := 0
+	cborItems(l.data, func(int, int) { n++ })
+	return n
+}
+
+// List decodes the whole list.
+func (l *LazyList) List() []interface{} {
+	list := []interface{}{}
+	cborItems(l.data, func(_, off int) {
+		v, _ := cborValue(l.data, off)
+		list = append(list, materialize(v))
+	})
+	return list
+}
+
+// materialize decodes the whole of the lazy values.
+func materialize(v interface{}) interface{} {
+	switch lv := v.(type) {
+	case *LazyNode:
+		return lv.Node()
+	case *LazyList:
+		return lv.List()
+	}
+	return v
+}
+
+// The CBOR major types.
+const (
+	cborUint = iota
+	cborNegInt
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+// cborIndefinite is the additional information of the items of indefinite
+// length, and of the break ending them.
+const cborIndefinite = 31
+
+func cborMajor(data []byte, off int) byte {
+	return data[off] >> 5
+}
+
+// cborHead reads the head of the item at off. It returns the major type, the
+// argument, which is the length of strings, lists and maps, whether the
+// length is indefinite, and the offset following the head.
+func cborHead(data []byte, off int) (major byte, arg uint64, indefinite bool, next int, err error) {
+	if off >= len(data) {
+		return 0, 0, false, 0, errLazyTruncated
+	}
+	major, info := data[off]>>5, data[off]&0x1f
+	off++
+
+	size := 0
+	switch {
+	case info < 24:
+		return major, uint64(info), false, off, nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	case info == cborIndefinite:
+		if major != cborBytes && major != cborText && major != cborArray && major != cborMap && major != cborSimple {
+			return 0, 0, false, 0, errLazyInvalid
+		}
+		return major, 0, true, off, nil
+	default:
+		return 0, 0, false, 0, errLazyInvalid
+	}
+
+	if len(data)-off < size {
+		return 0, 0, false, 0, errLazyTruncated
+	}
+	var buf [8]byte
+	copy(buf[8-size:], data[off:off+size])
+	return major, binary.BigEndian.Uint64(buf[:]), false, off + size, nil
+}
+
+// cborSkip checks the item at off, nested at depth, and returns the offset
+// following it. Like convert, it refuses to nest items deeper than maxDepth.
+func cborSkip(data []byte, off int, depth int) (int, error) {
+	major, arg, indefinite, next, err := cborHead(data, off)
+	if err != nil {
+		return 0, err
+	}
+	if (major == cborArray || major == cborMap || major == cborTag || indefinite) && depth > maxDepth {
+		return 0, &LimitError{DepthLimit, maxDepth}
+	}
+
+	switch major {
+	case cborUint, cborNegInt:
+		return next, nil
+
+	case cborBytes, cborText:
+		if !indefinite {
+			if uint64(len(data)-next) < arg {
+				return 0, errLazyTruncated
+			}
+			return next + int(arg), nil
+		}
+		// the chunks are definite strings of the same type
+		for {
+			if next >= len(data) {
+				return 0, errLazyTruncated
+			}
+			if data[next] == 0xff {
+				return next + 1, nil
+			}
+			if cborMajor(data, next) != major || data[next]&0x1f == cborIndefinite {
+				return 0, errLazyInvalid
+			}
+			if next, err = cborSkip(data, next, depth+1); err != nil {
+				return 0, err
+			}
+		}
+
+	case cborArray, cborMap:
+		// every item is at least one byte long
+		if !indefinite && arg > uint64(len(data)-next) {
+			return 0, errLazyTruncated
+		}
+		if major == cborMap {
+			arg *= 2
+		}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			if indefinite && next < len(data) && data[next] == 0xff {
+				if major == cborMap && i%2 == 1 {
+					return 0, errLazyInvalid
+				}
+				return next + 1, nil
+			}
+			if next, err = cborSkip(data, next, depth+1); err != nil {
+				return 0, err
+			}
+		}
+		return next, nil
+
+	case cborTag:
+		return cborSkip(data, next, depth+1)
+
+	default: // simple values and floats
+		if indefinite {
+			return 0, errLazyInvalid // unexpected break
+		}
+		switch info := data[off] & 0x1f; {
+		case info >= 20 && info <= 23, info >= 25 && info <= 27:
+			return next, nil
+		}
+		return 0, errLazyInvalid
+	}
+}
+
+// cborItems calls f with the index and the offset of each item of the list
+// or map held by data, the keys and values of maps being items of their own.
+// The list or map must have been checked by cborSkip.
+func cborItems(data []byte, f func(i, off int)) {
+	major, arg, indefinite, next, _ := cborHead(data, 0)
+	if major == cborMap {
+		arg *= 2
+	}
+	for i := 0; indefinite || uint64(i) < arg; i++ {
+		if indefinite && data[next] == 0xff {
+			return
+		}
+		f(i, next)
+		next, _ = cborSkip(data, next, 1)
+	}
+}
+
+// cborKey returns the content of the text string at off, if the item is one.
+func cborKey(data []byte, off int) ([]byte, bool) {
+	for cborMajor(data, off) == cborTag {
+		_, _, _, off, _ = cborHead(data, off)
+	}
+	if cborMajor(data, off) != cborText {
+		return nil, false
+	}
+	b, _ := cborBytesValue(data, off)
+	return b, true
+}
+
+// cborBytesValue returns the content of the byte or text string at off. The
+// content of definite strings aliases data.
+func cborBytesValue(data []byte, off int) ([]byte, int) {
+	_, arg, indefinite, next, _ := cborHead(data, off)
+	if !indefinite {
+		end := next + int(arg)
+		return data[next:end:end], end
+	}
+
+	b := []byte{}
+	for data[next] != 0xff {
+		var chunk []byte
+		chunk, next = cborBytesValue(data, next)
+		b = append(b, chunk...)
+	}
+	return b, next + 1
+}
+
+// cborValue decodes the item at off, following the number model of convert,
+// and returns the offset following it. The item must have been checked by
+// cborSkip.
+func cborValue(data []byte, off int) (interface{}, int) {
+	for cborMajor(data, off) == cborTag {
+		_, _, _, off, _ = cborHead(data, off)
+	}
+	major, arg, _, next, _ := cborHead(data, off)
+
+	switch major {
+	case cborUint:
+		if arg <= math.MaxInt64 {
+			return int64(arg), next
+		}
+		return arg, next
+	case cborNegInt:
+		if arg <= math.MaxInt64 {
+			return -1 - int64(arg), next
+		}
+		b := new(big.Int).SetUint64(arg)
+		return b.Sub(big.NewInt(-1), b), next
+	case cborBytes:
+		return cborBytesValue(data, off)
+	case cborText:
+		b, end := cborBytesValue(data, off)
+		return string(b), end
+	case cborArray, cborMap:
+		end, _ := cborSkip(data, off, 1)
+		if major == cborArray {
+			return &LazyList{data[off:end:end]}, end
+		}
+		return &LazyNode{data[off:end:end]}, end
+	}
+
+	switch data[off] & 0x1f {
+	case 20:
+		return false, next
+	case 21:
+		return true, next
+	case 25:
+		return halfFloat(uint16(arg)), next
+	case 26:
+		return float64(math.Float32frombits(uint32(arg))), next
+	case 27:
+		return math.Float64frombits(arg), next
+	}
+	return nil, next // null and undefined
+}
+
+// halfFloat converts a half-precision float.
+func halfFloat(h uint16) float64 {
+	exp, mant := int(h>>10)&0x1f, float64(h&0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
@@ -0,0 +1,191 @@
+package ipfsld
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	mc "github.com/jbenet/go-multicodec"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+func TestLazyFixtures(t *testing.T) {
+	files, err := filepath.Glob("testdata/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range files {
+		codec := Multicodec()
+		if strings.HasSuffix(f, ".cbor") {
+			codec = CborMulticodec()
+		} else if !strings.HasSuffix(f, ".multicodec") {
+			continue
+		}
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var expected ipld.Node
+		if err := mc.Unmarshal(codec, data, &expected); err != nil {
+			t.Fatalf("%s: %s", f, err)
+		}
+		n, err := DecodeLazy(data)
+		if err == errLazyCodec {
+			continue // not CBOR
+		} else if err != nil {
+			t.Errorf("%s: %s", f, err)
+			continue
+		}
+		if decoded := n.Node(); !reflect.DeepEqual(decoded, expected) {
+			t.Errorf("%s: decoded as %#v, expected %#v", f, decoded, expected)
+		}
+	}
+}
+
+func TestLazyGet(t *testing.T) {
+	data := bytes.Repeat([]byte{0xaa}, 1<<16)
+	n := ipld.Node{
+		"name":  "foo",
+		"count": int64(-42),
+		"big":   uint64(math.MaxUint64),
+		"ratio": 0.5,
+		"ok":    true,
+		"none":  nil,
+		"data":  data,
+		"link":  ipld.Node{ipld.LinkKey: "QmZku7P7KeeHAnwMr6c4HveYfMzmtVinNXzibkiNbfDbPo"},
+		"@type": "test",
+		"list":  []interface{}{"a", ipld.Node{"b": int64(1)}},
+	}
+	encoded, err := mc.Marshal(CborMulticodec(), &n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := DecodeLazy(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]interface{}{
+		"/name":                 "foo",
+		"/count":                int64(-42),
+		"/big":                  uint64(math.MaxUint64),
+		"/ratio":                0.5,
+		"/ok":                   true,
+		"/none":                 nil,
+		"/missing":              nil,
+		"/@type":                nil, // directives are not path components
+		"/list/0":               "a",
+		"/list/1/b":             int64(1),
+		"/list/2":               nil,
+		"/link/" + ipld.LinkKey: "QmZku7P7KeeHAnwMr6c4HveYfMzmtVinNXzibkiNbfDbPo",
+	} {
+		if v := ln.Get(path); !reflect.DeepEqual(v, expected) {
+			t.Errorf("%s: got %#v, expected %#v", path, v, expected)
+		}
+	}
+	if len(ln.Keys()) != len(n) {
+		t.Errorf("unexpected keys: %v", ln.Keys())
+	}
+	if l, ok := ln.Get("/list").(*LazyList); !ok || l.Len() != 2 {
+		t.Errorf("unexpected list: %#v", ln.Get("/list"))
+	}
+	if link, ok := ln.Get("/link").(*LazyNode); !ok || !reflect.DeepEqual(link.Node(), n["link"]) {
+		t.Errorf("unexpected link: %#v", ln.Get("/link"))
+	}
+
+	// byte strings alias the encoded object
+	b := ln.Get("/data").([]byte)
+	if !bytes.Equal(b, data) {
+		t.Fatal("unexpected data")
+	}
+	if i := bytes.Index(encoded, data); i < 0 || &encoded[i] != &b[0] {
+		t.Error("data copied out of the encoded object")
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		ln.Get("/data")
+	})
+	if allocs > 5 {
+		t.Errorf("reading a byte string made %v allocations", allocs)
+	}
+}
+
+func TestLazyInvalid(t *testing.T) {
+	cbor := func(s string) []byte {
+		return append(CborMulticodec().Header(), s...)
+	}
+
+	for _, data := range [][]byte{
+		[]byte("no header"),
+		append(JsonMulticodec().Header(), "{}\n"...),
+		cbor(""),
+		cbor("\x82\x01"),         // truncated list
+		cbor("\xa1\x61a"),        // truncated map
+		cbor("\x5a\xff\xff\xff"), // truncated length
+		cbor("\x43ab"),           // truncated byte string
+		cbor("\xbb\x80\x00\x00\x00\x00\x00\x00\x00"), // huge map
+		cbor("\xa0\x00"),              // trailing data
+		cbor("\x80"),                  // not a map
+		cbor("\xa1\x01\xfc"),          // reserved additional information
+		cbor("\xa1\x01\xf0"),          // unassigned simple value
+		cbor("\xa1\x01\xff"),          // unexpected break
+		cbor("\xbf\x01\xff"),          // break after a key
+		cbor("\xa1\x01\x5f\x61a\xff"), // text chunk in a byte string
+	} {
+		if _, err := DecodeLazy(data); err == nil {
+			t.Errorf("decoded invalid object %q", data)
+		}
+	}
+}
+
+func TestLazyDepth(t *testing.T) {
+	// {"a": x}, x being n nested lists or tags around 0
+	nested := func(b string, n int) []byte {
+		data := append(CborMulticodec().Header(), "\xa1\x61a"...)
+		return append(append(data, strings.Repeat(b, n)...), 0)
+	}
+
+	for _, b := range []string{"\x81", "\xc0"} {
+		if _, err := DecodeLazy(nested(b, maxDepth-1)); err != nil {
+			t.Errorf("%q: %s", b, err)
+		}
+		for _, n := range []int{maxDepth, 4 << 20} {
+			_, err := DecodeLazy(nested(b, n))
+			if err, ok := err.(*LimitError); !ok || err.Kind != DepthLimit {
+				t.Errorf("%q nested %d times: expected a depth LimitError, got %v", b, n, err)
+			}
+		}
+	}
+
+	n, err := DecodeLazy(nested("\xc0", maxDepth-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := n.Child("a"); v != int64(0) {
+		t.Errorf("tagged value decoded as %#v", v)
+	}
+}
+
+func TestLazyIndefinite(t *testing.T) {
+	// {"a": h'0102' (in chunks), "b": [_ 1, -1.5 (half float)]}
+	data := append(CborMulticodec().Header(), "\xbf\x61a\x5f\x41\x01\x41\x02\xff\x61b\x9f\x01\xf9\xbe\x00\xff\xff"...)
+	n, err := DecodeLazy(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ipld.Node{
+		"a": []byte{1, 2},
+		"b": []interface{}{int64(1), -1.5},
+	}
+	if decoded := n.Node(); !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("decoded as %#v", decoded)
+	}
+}
@@ -0,0 +1,499 @@
+package ipfsld
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+
+	mc "github.com/jbenet/go-multicodec"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+// Limits bounds the resources used to decode a single object. A zero field
+// means no limit.
+type Limits struct {
+	MaxDepth         int   // nesting of maps and lists, the root being at depth 1
+	MaxCollectionLen int   // number of entries of a map or list
+	MaxValueBytes    int   // length of a string or byte string, map keys included
+	MaxTotalSize     int64 // bytes read to decode the object, headers included
+}
+
+// LimitKind tells which limit was exceeded.
+type LimitKind int
+
+const (
+	DepthLimit LimitKind = iota
+	CollectionLimit
+	ValueLimit
+	SizeLimit
+)
+
+func (k LimitKind) String() string {
+	switch k {
+	case DepthLimit:
+		return "max depth"
+	case CollectionLimit:
+		return "max collection length"
+	case ValueLimit:
+		return "max bytes per value"
+	case SizeLimit:
+		return "max total size"
+	default:
+		return "unknown limit"
+	}
+}
+
+// LimitError is returned when decoding an object exceeds one of the Limits.
+type LimitError struct {
+	Kind LimitKind
+	Max  int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("decoding limit exceeded: %s is %d", e.Kind, e.Max)
+}
+
+// maxDepth bounds the nesting of every decoded object, even without Limits,
+// so that hostile input cannot exhaust the stack. encoding/json enforces the
+// same bound.
+const maxDepth = 10000
+
+// decodeLimits returns l, with its depth bounded by maxDepth.
+func decodeLimits(l Limits) *Limits {
+	if l.MaxDepth <= 0 || l.MaxDepth > maxDepth {
+		l.MaxDepth = maxDepth
+	}
+	return &l
+}
+
+// LimitedMulticodec is like Multicodec, but its decoders enforce the given
+// limits, whatever the codec of the decoded object. The total size is
+// enforced while reading, headers included, so that no more than
+// MaxTotalSize bytes are ever read. The CBOR, JSON and MessagePack decoders
+// check the other limits while decoding, before allocating the values. All
+// the decoded values are checked again before they are converted to
+// ipld.Node, which covers the other codecs, whose input is either read whole
+// within MaxTotalSize or has a fixed nesting.
+//
+// The returned codec only knows about the codecs registered at the time it
+// is created.
+func LimitedMulticodec(l Limits) mc.Multicodec {
+	codecs := List()
+	limited := make([]mc.Multicodec, len(codecs))
+	for i, c := range codecs {
+		limited[i] = &limitedCodec{c, l}
+	}
+	return &limitedMux{newCodecMux(limited), l}
+}
+
+// limitingCodec is implemented by the codecs whose decoders can enforce the
+// limits while decoding. They are the codecs wrapped by transformCodec, and
+// their decoders produce values still to be converted.
+type limitingCodec interface {
+	limitedDecoder(r io.Reader, l Limits) mc.Decoder
+}
+
+// limitedMux is the mux of the limited codecs. Its decoders count the bytes
+// of the mux header too.
+type limitedMux struct {
+	*codecMux
+	limits Limits
+}
+
+type limitedMuxDecoder struct {
+	mc.Decoder
+	r *limitedReader
+}
+
+func (c *limitedMux) Decoder(r io.Reader) mc.Decoder {
+	lr := &limitedReader{r: r, max: c.limits.MaxTotalSize}
+	return &limitedMuxDecoder{c.codecMux.Decoder(lr), lr}
+}
+
+func (d *limitedMuxDecoder) Decode(v interface{}) error {
+	d.r.read = 0 // the limits apply to each object
+	err := d.Decoder.Decode(v)
+	if d.r.exceeded {
+		return &LimitError{SizeLimit, d.r.max}
+	}
+	return err
+}
+
+type limitedCodec struct {
+	mc.Multicodec
+	limits Limits
+}
+
+type limitedDecoder struct {
+	mc.Decoder
+	limits  Limits
+	convert bool // whether the decoded values are converted after the check
+}
+
+func (c *limitedCodec) Decoder(r io.Reader) mc.Decoder {
+	// check the raw values of our own codecs before convert() walks them
+	if tc, ok := c.Multicodec.(*transformCodec); ok {
+		if lc, ok := tc.Multicodec.(limitingCodec); ok {
+			return &limitedDecoder{lc.limitedDecoder(r, c.limits), c.limits, true}
+		}
+		return &limitedDecoder{tc.Multicodec.Decoder(r), c.limits, true}
+	}
+	return &limitedDecoder{c.Multicodec.Decoder(r), c.limits, false}
+}
+
+func (c *limitedDecoder) Decode(v interface{}) error {
+	if err := c.Decoder.Decode(v); err != nil {
+		return err
+	}
+
+	if err := checkLimits(v, &c.limits, 1); err != nil {
+		return err
+	}
+	if c.convert {
+		if _, err := convert(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkLimits checks the value v, found at the given depth, against the
+// limits. It never descends deeper than MaxDepth.
+func checkLimits(v interface{}, l *Limits, depth int) error {
+	var children []interface{}
+	var keys []string
+	var length int
+
+	switch vv := v.(type) {
+	case *ipld.Node:
+		return checkLimits(*vv, l, depth)
+	case *map[string]interface{}:
+		return checkLimits(*vv, l, depth)
+	case *map[interface{}]interface{}:
+		return checkLimits(*vv, l, depth)
+	case *[]interface{}:
+		return checkLimits(*vv, l, depth)
+	case ipld.Node:
+		return checkLimits(map[string]interface{}(vv), l, depth)
+	case map[string]interface{}:
+		length = len(vv)
+		for k, c := range vv {
+			keys = append(keys, k)
+			children = append(children, c)
+		}
+	case map[interface{}]interface{}:
+		length = len(vv)
+		for k, c := range vv {
+			children = append(children, k, c)
+		}
+	case []interface{}:
+		length = len(vv)
+		children = vv
+	case []ipld.Node:
+		length = len(vv)
+		for _, c := range vv {
+			children = append(children, c)
+		}
+	case string:
+		return checkValueLen(len(vv), l)
+	case json.Number:
+		return checkValueLen(len(vv), l)
+	case []byte:
+		return checkValueLen(len(vv), l)
+	default:
+		return nil
+	}
+
+	if err := checkDepth(depth, l); err != nil {
+		return err
+	}
+	if err := checkCollectionLen(uint64(length), l); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if err := checkValueLen(len(k), l); err != nil {
+			return err
+		}
+	}
+	for _, c := range children {
+		if err := checkLimits(c, l, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkValueLen(n int, l *Limits) error {
+	return checkValueLen64(uint64(n), l)
+}
+
+func checkValueLen64(n uint64, l *Limits) error {
+	if l.MaxValueBytes > 0 && n > uint64(l.MaxValueBytes) {
+		return &LimitError{ValueLimit, int64(l.MaxValueBytes)}
+	}
+	return nil
+}
+
+func checkDepth(depth int, l *Limits) error {
+	if l.MaxDepth > 0 && depth > l.MaxDepth {
+		return &LimitError{DepthLimit, int64(l.MaxDepth)}
+	}
+	return nil
+}
+
+func checkCollectionLen(n uint64, l *Limits) error {
+	if l.MaxCollectionLen > 0 && n > uint64(l.MaxCollectionLen) {
+		return &LimitError{CollectionLimit, int64(l.MaxCollectionLen)}
+	}
+	return nil
+}
+
+// cborScanner reads a single CBOR item into buf, checking it against the
+// limits as it goes: the lengths of strings, maps and lists are checked
+// before their content is read, and the buffer only grows as the data
+// arrives, so that a corrupt length allocates no more than the input.
+type cborScanner struct {
+	r   io.Reader
+	l   *Limits
+	buf []byte
+}
+
+// item reads the item found at the given depth.
+func (s *cborScanner) item(depth int) error {
+	off := len(s.buf)
+	major, arg, indefinite, err := s.head()
+	if err != nil {
+		return err
+	}
+	if major == cborSimple && indefinite {
+		return errLazyInvalid // unexpected break
+	}
+	return s.value(off, major, arg, indefinite, depth)
+}
+
+// head reads the head of an item.
+func (s *cborScanner) head() (major byte, arg uint64, indefinite bool, err error) {
+	off := len(s.buf)
+	if err := s.read(1); err != nil {
+		return 0, 0, false, err
+	}
+	switch s.buf[off] & 0x1f {
+	case 24:
+		err = s.read(1)
+	case 25:
+		err = s.read(2)
+	case 26:
+		err = s.read(4)
+	case 27:
+		err = s.read(8)
+	}
+	if err != nil {
+		return 0, 0, false, err
+	}
+	major, arg, indefinite, _, err = cborHead(s.buf, off)
+	return major, arg, indefinite, err
+}
+
+// value reads the rest of the item whose head is at off.
+func (s *cborScanner) value(off int, major byte, arg uint64, indefinite bool, depth int) error {
+	switch major {
+	case cborUint, cborNegInt:
+		return nil
+
+	case cborBytes, cborText:
+		if !indefinite {
+			if err := checkValueLen64(arg, s.l); err != nil {
+				return err
+			}
+			return s.read(arg)
+		}
+		// the chunks are definite strings of the same type
+		var total uint64
+		for {
+			cmajor, carg, cindefinite, err := s.head()
+			if err != nil {
+				return err
+			}
+			if cmajor == cborSimple && cindefinite {
+				return nil
+			}
+			if cmajor != major || cindefinite {
+				return errLazyInvalid
+			}
+			if total += carg; total < carg {
+				return errLazyInvalid
+			}
+			if err := checkValueLen64(total, s.l); err != nil {
+				return err
+			}
+			if err := s.read(carg); err != nil {
+				return err
+			}
+		}
+
+	case cborArray, cborMap:
+		if err := checkDepth(depth, s.l); err != nil {
+			return err
+		}
+		if !indefinite {
+			if err := checkCollectionLen(arg, s.l); err != nil {
+				return err
+			}
+		}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			if indefinite {
+				ioff := len(s.buf)
+				imajor, iarg, iindefinite, err := s.head()
+				if err != nil {
+					return err
+				}
+				if imajor == cborSimple && iindefinite {
+					return nil
+				}
+				if err := checkCollectionLen(i+1, s.l); err != nil {
+					return err
+				}
+				if err := s.value(ioff, imajor, iarg, iindefinite, depth+1); err != nil {
+					return err
+				}
+			} else if err := s.item(depth + 1); err != nil {
+				return err
+			}
+			if major == cborMap {
+				if err := s.item(depth + 1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+
+	case cborTag:
+		if err := checkDepth(depth, s.l); err != nil {
+			return err
+		}
+		return s.item(depth + 1)
+
+	default: // simple values and floats
+		switch info := s.buf[off] & 0x1f; {
+		case info >= 20 && info <= 23, info >= 25 && info <= 27:
+			return nil
+		}
+		return errLazyInvalid
+	}
+}
+
+// read appends the next n bytes of the input to buf.
+func (s *cborScanner) read(n uint64) error {
+	if n > math.MaxInt64 {
+		return io.ErrUnexpectedEOF
+	}
+	b := bytes.NewBuffer(s.buf)
+	read, err := io.Copy(b, io.LimitReader(s.r, int64(n)))
+	s.buf = b.Bytes()
+	if err != nil {
+		return err
+	}
+	if uint64(read) != n {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// checkJsonLimits checks a JSON value against the limits, before it is
+// decoded. Byte strings are maps of base64 strings in JSON, so the depth and
+// value length are only bounded here, with room for them: the exact limits
+// are checked on the decoded values.
+func checkJsonLimits(raw []byte, l *Limits) error {
+	bounds := *l
+	if bounds.MaxDepth > 0 {
+		bounds.MaxDepth++
+	}
+	if bounds.MaxValueBytes > 0 {
+		bounds.MaxValueBytes = base64.StdEncoding.EncodedLen(bounds.MaxValueBytes)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	// the number of tokens read in each open map or list, a map entry being
+	// two tokens
+	var counts []uint64
+	var maps []bool
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if d, ok := tok.(json.Delim); ok && (d == '}' || d == ']') {
+			counts, maps = counts[:len(counts)-1], maps[:len(maps)-1]
+			continue
+		}
+		if top := len(counts) - 1; top >= 0 {
+			counts[top]++
+			n := counts[top]
+			if maps[top] {
+				n = (n + 1) / 2
+			}
+			if err := checkCollectionLen(n, &bounds); err != nil {
+				return err
+			}
+		}
+
+		switch t := tok.(type) {
+		case json.Delim:
+			if err := checkDepth(len(counts)+1, &bounds); err != nil {
+				return err
+			}
+			counts, maps = append(counts, 0), append(maps, t == '{')
+		case string:
+			if err := checkValueLen(len(t), &bounds); err != nil {
+				return err
+			}
+		case json.Number:
+			if err := checkValueLen(len(t), &bounds); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// limitedReader reads at most max bytes from r, if max is not zero. Unlike
+// io.LimitReader, it tells whether the input was actually longer than max.
+type limitedReader struct {
+	r        io.Reader
+	max      int64
+	read     int64
+	exceeded bool
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if r.max <= 0 {
+		return r.r.Read(p)
+	}
+
+	if r.read >= r.max {
+		// only fail if there actually is more to read.
+		var b [1]byte
+		n, err := r.r.Read(b[:])
+		if n > 0 {
+			r.exceeded = true
+			return 0, &LimitError{SizeLimit, r.max}
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > r.max-r.read {
+		p = p[:r.max-r.read]
+	}
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	return n, err
+}
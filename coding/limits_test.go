@@ -0,0 +1,158 @@
+package ipfsld
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	ipld "github.com/ipfs/go-ipld"
+
+	mc "github.com/jbenet/go-multicodec"
+)
+
+func TestLimits(t *testing.T) {
+	pbfile, err := ioutil.ReadFile("pb/testfile")
+	if err != nil {
+		t.Fatal("could not read pb/testfile. please run: make -C pb testfile")
+	}
+	pbfile = append(Multicodec().Header(), pbfile...)
+
+	deep := ipld.Node{"a": ipld.Node{"b": []interface{}{ipld.Node{"c": "d"}}}}
+	long := ipld.Node{"a": []interface{}{"1", "2", "3", "4", "5"}}
+	big := ipld.Node{"a": strings.Repeat("x", 100)}
+	bigKey := ipld.Node{strings.Repeat("x", 100): "a"}
+
+	for _, codec := range []string{"/cbor", "/json", "/msgpack"} {
+		for _, tc := range []struct {
+			n      ipld.Node
+			limits Limits
+			kind   LimitKind
+		}{
+			{deep, Limits{MaxDepth: 3}, DepthLimit},
+			{long, Limits{MaxCollectionLen: 4}, CollectionLimit},
+			{big, Limits{MaxValueBytes: 99}, ValueLimit},
+			{bigKey, Limits{MaxValueBytes: 99}, ValueLimit},
+			{big, Limits{MaxTotalSize: 100}, SizeLimit},
+		} {
+			n := ipld.Node{}
+			for k, v := range tc.n {
+				n[k] = v
+			}
+			n[ipld.CodecKey] = codec
+
+			encoded, err := mc.Marshal(Multicodec(), &n)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var n2 ipld.Node
+			err = mc.Unmarshal(LimitedMulticodec(tc.limits), encoded, &n2)
+			if lerr, ok := err.(*LimitError); !ok || lerr.Kind != tc.kind {
+				t.Errorf("%s: expected %s error, got %v", codec, tc.kind, err)
+			}
+
+			// one more and it fits
+			l := tc.limits
+			if l.MaxDepth > 0 {
+				l.MaxDepth++
+			}
+			if l.MaxCollectionLen > 0 {
+				l.MaxCollectionLen++
+			}
+			if l.MaxValueBytes > 0 {
+				l.MaxValueBytes++
+			}
+			if l.MaxTotalSize > 0 {
+				l.MaxTotalSize = int64(len(encoded))
+			}
+			if err := mc.Unmarshal(LimitedMulticodec(l), encoded, &n2); err != nil {
+				t.Errorf("%s: %s", codec, err)
+			} else if len(n2.Links()) != len(n.Links()) || n2[ipld.CodecKey] != codec {
+				t.Errorf("%s: decoded node was not converted: %#v", codec, n2)
+			}
+		}
+	}
+
+	var n ipld.Node
+	err = mc.Unmarshal(LimitedMulticodec(Limits{MaxCollectionLen: 3}), pbfile, &n)
+	if lerr, ok := err.(*LimitError); !ok || lerr.Kind != CollectionLimit {
+		t.Errorf("/mdagv1: expected %s error, got %v", CollectionLimit, err)
+	}
+	err = mc.Unmarshal(LimitedMulticodec(Limits{MaxTotalSize: 100}), pbfile, &n)
+	if lerr, ok := err.(*LimitError); !ok || lerr.Kind != SizeLimit {
+		t.Errorf("/mdagv1: expected %s error, got %v", SizeLimit, err)
+	}
+}
+
+func TestLimitsWhileDecoding(t *testing.T) {
+	header := func(c mc.Multicodec) string {
+		return string(Multicodec().Header()) + string(c.Header())
+	}
+	cbor, msgpack := header(CborMulticodec()), header(MsgpackMulticodec())
+	depth := 100000
+
+	// even without limits, deep nesting is refused rather than exhausting
+	// the stack
+	for name, data := range map[string]string{
+		"/cbor":      cbor + "\xa1\x61a" + strings.Repeat("\x81", depth) + "\x01",
+		"/cbor tags": cbor + "\xa1\x61a" + strings.Repeat("\xc0", depth) + "\x01",
+		"/msgpack":   msgpack + "\x81\xa1a" + strings.Repeat("\x91", depth) + "\x01",
+	} {
+		var n ipld.Node
+		err := mc.Unmarshal(Multicodec(), []byte(data), &n)
+		if lerr, ok := err.(*LimitError); !ok || lerr.Kind != DepthLimit {
+			t.Errorf("%s: expected %s error, got %v", name, DepthLimit, err)
+		}
+	}
+	deepJson := header(JsonMulticodec()) + `{"a":` + strings.Repeat("[", depth) + strings.Repeat("]", depth) + "}"
+	var n ipld.Node
+	if err := mc.Unmarshal(Multicodec(), []byte(deepJson), &n); err == nil {
+		t.Error("/json: decoded deeply nested object")
+	}
+
+	// corrupt lengths are refused before anything is allocated for them
+	for _, tc := range []struct {
+		data   string
+		limits Limits
+		kind   LimitKind
+	}{
+		{cbor + "\xa1\x61a\x5b\x3f\xff\xff\xff\xff\xff\xff\xff", Limits{MaxValueBytes: 1000}, ValueLimit},
+		{cbor + "\xa1\x61a\x9b\x3f\xff\xff\xff\xff\xff\xff\xff", Limits{MaxCollectionLen: 1000}, CollectionLimit},
+		{msgpack + "\x81\xa1a\xc6\xff\xff\xff\xff", Limits{MaxValueBytes: 1000}, ValueLimit},
+		{msgpack + "\x81\xa1a\xdd\xff\xff\xff\xff", Limits{MaxCollectionLen: 1000}, CollectionLimit},
+	} {
+		var n ipld.Node
+		err := mc.Unmarshal(LimitedMulticodec(tc.limits), []byte(tc.data+"xx"), &n)
+		if lerr, ok := err.(*LimitError); !ok || lerr.Kind != tc.kind {
+			t.Errorf("%q: expected %s error, got %v", tc.data, tc.kind, err)
+		}
+		if err := mc.Unmarshal(Multicodec(), []byte(tc.data+"xx"), &n); err != io.ErrUnexpectedEOF {
+			t.Errorf("%q: expected %v, got %v", tc.data, io.ErrUnexpectedEOF, err)
+		}
+	}
+
+	// tags count in the depth, like lists and maps
+	tagged := []byte(cbor + "\xa1\x61a\xc6\xc6\xc6\x01")
+	err := mc.Unmarshal(LimitedMulticodec(Limits{MaxDepth: 3}), tagged, &n)
+	if lerr, ok := err.(*LimitError); !ok || lerr.Kind != DepthLimit {
+		t.Errorf("tag chain: expected %s error, got %v", DepthLimit, err)
+	}
+	if err := mc.Unmarshal(LimitedMulticodec(Limits{MaxDepth: 4}), tagged, &n); err != nil {
+		t.Errorf("tag chain: %s", err)
+	}
+
+	// the mux header counts in the total size
+	for _, codec := range []string{"/cbor", "/msgpack"} {
+		n := ipld.Node{ipld.CodecKey: codec, "a": "b"}
+		encoded, err := mc.Marshal(Multicodec(), &n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := Limits{MaxTotalSize: int64(len(encoded) - len(Multicodec().Header()))}
+		err = mc.Unmarshal(LimitedMulticodec(l), encoded, &n)
+		if lerr, ok := err.(*LimitError); !ok || lerr.Kind != SizeLimit {
+			t.Errorf("%s: expected %s error, got %v", codec, SizeLimit, err)
+		}
+	}
+}
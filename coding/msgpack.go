@@ -0,0 +1,469 @@
+package ipfsld
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"sort"
+
+	mc "github.com/jbenet/go-multicodec"
+	mh "github.com/jbenet/go-multihash"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+var msgpackHeader = mc.Header([]byte("/msgpack"))
+
+// The MessagePack extension types used for links and byte strings.
+const (
+	MsgpackLinkExt  = 1
+	MsgpackBytesExt = 2
+)
+
+var (
+	errMsgpackBigInt = errors.New("cannot encode integers larger than 64 bits in MessagePack")
+	errMsgpackKey    = errors.New("MessagePack map keys must be strings")
+	errMsgpackRoot   = errors.New("MessagePack object must be a map")
+)
+
+// MsgpackMulticodec returns the MessagePack codec. Its nodes follow the same
+// number model as the other codecs, and two extension types are defined:
+//
+//   - MsgpackLinkExt holds the multihash of a link, as the binary multihash.
+//     Only the links without other properties are encoded this way, the
+//     others are maps with a "mlink" key, as in the other codecs.
+//   - MsgpackBytesExt holds a byte string, so that it cannot be mistaken for
+//     a text string by the implementations predating the bin format. The bin
+//     format is decoded as a byte string too.
+//
+// The encoding is canonical: map keys are sorted, integers use their shortest
+// form and floats are always float 64.
+func MsgpackMulticodec() mc.Multicodec {
+	return &transformCodec{&msgpackCodec{}}
+}
+
+type msgpackCodec struct{}
+
+type msgpackEncoder struct {
+	w io.Writer
+}
+
+type msgpackDecoder struct {
+	r     io.Reader
+	l     *Limits
+	depth int // of the map or list being decoded
+	buf   [8]byte
+}
+
+func (c *msgpackCodec) Header() []byte {
+	return msgpackHeader
+}
+
+func (c *msgpackCodec) Encoder(w io.Writer) mc.Encoder {
+	return &msgpackEncoder{w}
+}
+
+func (c *msgpackCodec) Decoder(r io.Reader) mc.Decoder {
+	return c.limitedDecoder(r, Limits{})
+}
+
+func (c *msgpackCodec) limitedDecoder(r io.Reader, l Limits) mc.Decoder {
+	return &msgpackDecoder{r: r, l: decodeLimits(l)}
+}
+
+func (e *msgpackEncoder) Encode(v interface{}) error {
+	if hasBigInt(v) {
+		return errMsgpackBigInt
+	}
+
+	buf, err := appendMsgpack(nil, v)
+	if err != nil {
+		return err
+	}
+	if err := mc.WriteHeader(e.w, msgpackHeader); err != nil {
+		return err
+	}
+	_, err = e.w.Write(buf)
+	return err
+}
+
+// appendMsgpack appends the MessagePack encoding of val to buf.
+func appendMsgpack(buf []byte, val interface{}) ([]byte, error) {
+	switch v := val.(type) {
+	case *ipld.Node:
+		return appendMsgpack(buf, *v)
+	case ipld.Node:
+		return appendMsgpack(buf, map[string]interface{}(v))
+	case map[string]interface{}:
+		if l, ok := v[ipld.LinkKey].(string); ok && len(v) == 1 {
+			if h, err := mh.FromB58String(l); err == nil {
+				return appendMsgpackExt(buf, MsgpackLinkExt, h), nil
+			}
+		}
+
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		buf = appendMsgpackHead(buf, 0x80, 0xde, len(v), 16)
+		for _, k := range keys {
+			buf = appendMsgpackString(buf, k)
+			var err error
+			if buf, err = appendMsgpack(buf, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case []ipld.Node:
+		slice := make([]interface{}, len(v))
+		for i, n := range v {
+			slice[i] = n
+		}
+		return appendMsgpack(buf, slice)
+	case []interface{}:
+		buf = appendMsgpackHead(buf, 0x90, 0xdc, len(v), 16)
+		for _, c := range v {
+			var err error
+			if buf, err = appendMsgpack(buf, c); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case []byte:
+		return appendMsgpackExt(buf, MsgpackBytesExt, v), nil
+	case string:
+		return appendMsgpackString(buf, v), nil
+	case nil:
+		return append(buf, 0xc0), nil
+	case bool:
+		if v {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case int:
+		return appendMsgpackInt(buf, int64(v)), nil
+	case int64:
+		return appendMsgpackInt(buf, v), nil
+	case uint64:
+		return appendMsgpackUint(buf, v), nil
+	case float32:
+		return appendMsgpack(buf, float64(v))
+	case float64:
+		buf = append(buf, 0xcb)
+		return appendUint(buf, math.Float64bits(v), 8), nil
+	case *big.Int:
+		return nil, errMsgpackBigInt
+	}
+	return nil, fmt.Errorf("cannot encode %T in MessagePack", val)
+}
+
+// appendMsgpackHead appends the head of a map or an array of n items. Small
+// ones are encoded as fix, the others as code followed by their length on 16
+// or 32 bits.
+func appendMsgpackHead(buf []byte, fix, code byte, n int, max int) []byte {
+	switch {
+	case n < max:
+		return append(buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		return appendUint(append(buf, code), uint64(n), 2)
+	default:
+		return appendUint(append(buf, code+1), uint64(n), 4)
+	}
+}
+
+func appendMsgpackString(buf []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = appendUint(append(buf, 0xd9), uint64(n), 1)
+	default:
+		buf = appendMsgpackHead(buf, 0xa0, 0xda, n, 0)
+	}
+	return append(buf, s...)
+}
+
+func appendMsgpackExt(buf []byte, typ int8, data []byte) []byte {
+	switch n := len(data); {
+	case n == 1:
+		buf = append(buf, 0xd4)
+	case n == 2:
+		buf = append(buf, 0xd5)
+	case n == 4:
+		buf = append(buf, 0xd6)
+	case n == 8:
+		buf = append(buf, 0xd7)
+	case n == 16:
+		buf = append(buf, 0xd8)
+	case n <= math.MaxUint8:
+		buf = appendUint(append(buf, 0xc7), uint64(n), 1)
+	case n <= math.MaxUint16:
+		buf = appendUint(append(buf, 0xc8), uint64(n), 2)
+	default:
+		buf = appendUint(append(buf, 0xc9), uint64(n), 4)
+	}
+	buf = append(buf, byte(typ))
+	return append(buf, data...)
+}
+
+func appendMsgpackInt(buf []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return appendMsgpackUint(buf, uint64(i))
+	case i >= -32:
+		return append(buf, byte(i))
+	case i >= math.MinInt8:
+		return appendUint(append(buf, 0xd0), uint64(i), 1)
+	case i >= math.MinInt16:
+		return appendUint(append(buf, 0xd1), uint64(i), 2)
+	case i >= math.MinInt32:
+		return appendUint(append(buf, 0xd2), uint64(i), 4)
+	default:
+		return appendUint(append(buf, 0xd3), uint64(i), 8)
+	}
+}
+
+func appendMsgpackUint(buf []byte, u uint64) []byte {
+	switch {
+	case u <= 0x7f:
+		return append(buf, byte(u))
+	case u <= math.MaxUint8:
+		return appendUint(append(buf, 0xcc), u, 1)
+	case u <= math.MaxUint16:
+		return appendUint(append(buf, 0xcd), u, 2)
+	case u <= math.MaxUint32:
+		return appendUint(append(buf, 0xce), u, 4)
+	default:
+		return appendUint(append(buf, 0xcf), u, 8)
+	}
+}
+
+// appendUint appends the size low bytes of u, in big endian order.
+func appendUint(buf []byte, u uint64, size int) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], u)
+	return append(buf, b[8-size:]...)
+}
+
+func (d *msgpackDecoder) Decode(v interface{}) error {
+	vn, ok := v.(*ipld.Node)
+	if !ok {
+		return errors.New("must decode to *ipld.Node")
+	}
+
+	if err := mc.ConsumeHeader(d.r, msgpackHeader); err != nil {
+		return err
+	}
+	val, err := d.value()
+	if err != nil {
+		return err
+	}
+	m, ok := val.(map[string]interface{})
+	if !ok {
+		return errMsgpackRoot
+	}
+	*vn = ipld.Node(m)
+	return nil
+}
+
+// value decodes a single value. Maps and integers are returned as decoded by
+// the CBOR codec, for convert() to turn them into our node and number model.
+func (d *msgpackDecoder) value() (interface{}, error) {
+	b, err := d.uint(1)
+	if err != nil {
+		return nil, err
+	}
+	c := byte(b)
+
+	switch {
+	case c <= 0x7f:
+		return uint64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.mapValue(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.arrayValue(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		return d.stringValue(int(c & 0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6: // bin 8, 16, 32
+		n, err := d.uint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.bytes(n)
+	case 0xc7, 0xc8, 0xc9: // ext 8, 16, 32
+		n, err := d.uint(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.ext(n)
+	case 0xca:
+		u, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err := d.uint(8)
+		return math.Float64frombits(u), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return d.uint(1 << (c - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		u, err := d.uint(size)
+		// sign extend
+		shift := uint(64 - 8*size)
+		return int64(u<<shift) >> shift, err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8: // fixext 1, 2, 4, 8, 16
+		return d.ext(1 << (c - 0xd4))
+	case 0xd9, 0xda, 0xdb: // str 8, 16, 32
+		n, err := d.uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.stringValue(int(n))
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayValue(int(n))
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapValue(int(n))
+	}
+	return nil, fmt.Errorf("invalid MessagePack type 0x%x", c)
+}
+
+// enter checks the limits before decoding a map or a list of n items.
+func (d *msgpackDecoder) enter(n int) error {
+	d.depth++
+	if err := checkDepth(d.depth, d.l); err != nil {
+		return err
+	}
+	return checkCollectionLen(uint64(n), d.l)
+}
+
+func (d *msgpackDecoder) mapValue(n int) (interface{}, error) {
+	if err := d.enter(n); err != nil {
+		return nil, err
+	}
+	defer func() { d.depth-- }()
+
+	m := make(map[string]interface{}, minInt(n, 64))
+	for i := 0; i < n; i++ {
+		k, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		ks, ok := k.(string)
+		if !ok {
+			return nil, errMsgpackKey
+		}
+		if _, ok := m[ks]; ok {
+			return nil, fmt.Errorf("duplicate MessagePack map key %q", ks)
+		}
+
+		if m[ks], err = d.value(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (d *msgpackDecoder) arrayValue(n int) (interface{}, error) {
+	if err := d.enter(n); err != nil {
+		return nil, err
+	}
+	defer func() { d.depth-- }()
+
+	a := make([]interface{}, 0, minInt(n, 64))
+	for i := 0; i < n; i++ {
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+	}
+	return a, nil
+}
+
+func (d *msgpackDecoder) stringValue(n int) (interface{}, error) {
+	b, err := d.bytes(uint64(n))
+	return string(b), err
+}
+
+func (d *msgpackDecoder) ext(n uint64) (interface{}, error) {
+	typ, err := d.uint(1)
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.bytes(n)
+	if err != nil {
+		return nil, err
+	}
+
+	switch int8(typ) {
+	case MsgpackLinkExt:
+		h, err := mh.Cast(data)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{ipld.LinkKey: h.B58String()}, nil
+	case MsgpackBytesExt:
+		return data, nil
+	}
+	return nil, fmt.Errorf("unknown MessagePack extension type %d", int8(typ))
+}
+
+// uint reads an unsigned integer of size bytes, in big endian order.
+func (d *msgpackDecoder) uint(size int) (uint64, error) {
+	b := d.buf[8-size:]
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	for i := 0; i < 8-size; i++ {
+		d.buf[i] = 0
+	}
+	return binary.BigEndian.Uint64(d.buf[:]), nil
+}
+
+// bytes reads n bytes, the content of a string, a byte string or an
+// extension. The buffer grows as they are read, so that a corrupt length does
+// not allocate more than the input.
+func (d *msgpackDecoder) bytes(n uint64) ([]byte, error) {
+	if err := checkValueLen64(n, d.l); err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(d.r, int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(b)) != n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return b, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,132 @@
+package ipfsld
+
+import (
+	"bytes"
+	"io"
+	"math/big"
+	"reflect"
+	"testing"
+
+	ipld "github.com/ipfs/go-ipld"
+
+	mc "github.com/jbenet/go-multicodec"
+	mh "github.com/jbenet/go-multihash"
+)
+
+func TestMsgpack(t *testing.T) {
+	link := "QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39V"
+	h, err := mh.FromB58String(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// written by hand, with the non canonical forms other encoders may use
+	doc := []byte("\x89" +
+		"\xa6parent\xc7\x22\x01" + string(h) +
+		"\xa5named\x82\xa5mlink\xd9\x2e" + link + "\xa4size\x0c" +
+		"\xa4data\xc4\x03\x00\x01\xff" +
+		"\xa3ext\xd5\x02\x00\x01" +
+		"\xa7numbers\x96\x01\xff\xd0\x80\xcd\x01\x00\xca\x40\x20\x00\x00\xcf\xff\xff\xff\xff\xff\xff\xff\xff" +
+		"\xa5flags\x83\xa2on\xc3\xa3off\xc2\xa4none\xc0" +
+		"\xa4list\x92\xa1a\x80" +
+		"\xa4long\xda\x00\x03abc" +
+		"\xa5empty\xa0")
+	expected := ipld.Node{
+		"parent":  ipld.Node{ipld.LinkKey: link},
+		"named":   ipld.Node{ipld.LinkKey: link, "size": int64(12)},
+		"data":    []byte("\x00\x01\xff"),
+		"ext":     []byte("\x00\x01"),
+		"numbers": []interface{}{int64(1), int64(-1), int64(-128), int64(256), 2.5, uint64(18446744073709551615)},
+		"flags":   ipld.Node{"on": true, "off": false, "none": nil},
+		"list":    []interface{}{"a", ipld.Node{}},
+		"long":    "abc",
+		"empty":   "",
+	}
+
+	var n ipld.Node
+	if err := mc.Unmarshal(Multicodec(), append(append(Multicodec().Header(), msgpackHeader...), doc...), &n); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(n, expected) {
+		t.Logf("Expected: %#v", expected)
+		t.Logf("Actual:   %#v", n)
+		t.Fatal("unexpected MessagePack node")
+	}
+
+	// the canonical encoding uses the extension types and the shortest forms
+	encoded, err := mc.Marshal(MsgpackMulticodec(), &n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonical := append(append([]byte{}, msgpackHeader...), "\x89"+
+		"\xa4data\xc7\x03\x02\x00\x01\xff"+
+		"\xa5empty\xa0"+
+		"\xa3ext\xd5\x02\x00\x01"+
+		"\xa5flags\x83\xa4none\xc0\xa3off\xc2\xa2on\xc3"+
+		"\xa4list\x92\xa1a\x80"+
+		"\xa4long\xa3abc"+
+		"\xa5named\x82\xa5mlink\xd9\x2e"+link+"\xa4size\x0c"+
+		"\xa7numbers\x96\x01\xff\xd0\x80\xcd\x01\x00\xcb\x40\x04\x00\x00\x00\x00\x00\x00\xcf\xff\xff\xff\xff\xff\xff\xff\xff"+
+		"\xa6parent\xc7\x22\x01"+string(h)...)
+	if !bytes.Equal(encoded, canonical) {
+		t.Errorf("unexpected encoding:\n%q\n%q", encoded, canonical)
+	}
+}
+
+func TestMsgpackInvalid(t *testing.T) {
+	for _, n := range []ipld.Node{
+		{"big": new(big.Int).Lsh(big.NewInt(1), 64)},
+		{"chan": make(chan int)},
+	} {
+		if _, err := mc.Marshal(MsgpackMulticodec(), &n); err == nil {
+			t.Errorf("encoded invalid node %v", n)
+		}
+	}
+
+	for _, doc := range []string{
+		"\x92\x01\x02",                     // not a map
+		"\x81\x01\x02",                     // integer key
+		"\x82\xa1a\x01\xa1a\x02",           // duplicate key
+		"\x81\xa1a\xd4\x03\x00",            // unknown extension
+		"\x81\xa1a\xd4\x01\x00",            // invalid multihash
+		"\x81\xa1a\xc1",                    // reserved
+		"\x81\xa1a\xdb\xff\xff\xff\xffabc", // truncated string
+	} {
+		var n ipld.Node
+		err := mc.Unmarshal(MsgpackMulticodec(), append(append([]byte{}, msgpackHeader...), doc...), &n)
+		if err == nil {
+			t.Errorf("decoded invalid document %q", doc)
+		}
+	}
+}
+
+func TestMsgpackStream(t *testing.T) {
+	nodes := []ipld.Node{
+		{"a": int64(1)},
+		{"b": ipld.Node{ipld.LinkKey: "QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39V"}},
+	}
+
+	var buf bytes.Buffer
+	enc := MsgpackMulticodec().Encoder(&buf)
+	for i := range nodes {
+		if err := enc.Encode(&nodes[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the decoder reads no further than the end of each object
+	dec := MsgpackMulticodec().Decoder(&buf)
+	for _, expected := range nodes {
+		var n ipld.Node
+		if err := dec.Decode(&n); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(n, expected) {
+			t.Errorf("decoded %#v, expected %#v", n, expected)
+		}
+	}
+	var n ipld.Node
+	if err := dec.Decode(&n); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
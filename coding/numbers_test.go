@@ -0,0 +1,82 @@
+package ipfsld
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	ipld "github.com/ipfs/go-ipld"
+
+	mc "github.com/jbenet/go-multicodec"
+)
+
+func TestNumbers(t *testing.T) {
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+
+	src := ipld.Node{
+		"int":      int64(-5),
+		"small":    uint64(42),
+		"large":    uint64(1<<63 + 1),
+		"float":    float64(1),
+		"fraction": 2.5,
+		"list":     []interface{}{int64(1), float64(2)},
+	}
+	expected := ipld.Node{
+		"int":      int64(-5),
+		"small":    int64(42),
+		"large":    uint64(1<<63 + 1),
+		"float":    float64(1),
+		"fraction": 2.5,
+		"list":     []interface{}{int64(1), float64(2)},
+	}
+
+	for _, codec := range []string{"/json", "/cbor"} {
+		n := ipld.Node{}
+		for k, v := range src {
+			n[k] = v
+		}
+		n[ipld.CodecKey] = codec
+		expected[ipld.CodecKey] = codec
+
+		encoded, err := mc.Marshal(Multicodec(), &n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var n2 ipld.Node
+		if err := mc.Unmarshal(Multicodec(), encoded, &n2); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(n2, expected) {
+			t.Errorf("%s: Expected: %#v", codec, expected)
+			t.Errorf("%s: Actual:   %#v", codec, n2)
+		}
+
+		// the decoded node encodes to the same bytes
+		encoded2, err := mc.Marshal(Multicodec(), &n2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(encoded) != string(encoded2) {
+			t.Errorf("%s: round trip changed the encoding", codec)
+		}
+	}
+
+	n := ipld.Node{ipld.CodecKey: "/json", "huge": huge}
+	encoded, err := mc.Marshal(Multicodec(), &n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n2 ipld.Node
+	if err := mc.Unmarshal(Multicodec(), encoded, &n2); err != nil {
+		t.Fatal(err)
+	}
+	if b, ok := n2["huge"].(*big.Int); !ok || b.Cmp(huge) != 0 {
+		t.Errorf("big integer not decoded: %#v", n2["huge"])
+	}
+
+	n2[ipld.CodecKey] = "/cbor"
+	if _, err := mc.Marshal(Multicodec(), &n2); err != errCborBigInt {
+		t.Errorf("expected errCborBigInt, got %v", err)
+	}
+}
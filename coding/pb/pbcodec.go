@@ -4,9 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
 
 	mc "github.com/jbenet/go-multicodec"
 	mcproto "github.com/jbenet/go-multicodec/protobuf"
+	mh "github.com/jbenet/go-multihash"
 
 	ipld "github.com/ipfs/go-ipld"
 )
@@ -16,6 +19,8 @@ var Header []byte
 var (
 	errInvalidData = fmt.Errorf("invalid merkledag v1 protobuf, Data not bytes")
 	errInvalidLink = fmt.Errorf("invalid merkledag v1 protobuf, invalid Links")
+	errInvalidKey  = fmt.Errorf("invalid merkledag v1 protobuf, unexpected key")
+	errEmptyNode   = fmt.Errorf("invalid merkledag v1 protobuf, no @attrs and no links")
 )
 
 func init() {
@@ -23,12 +28,25 @@ func init() {
 }
 
 type codec struct {
-	pbc mc.Multicodec
+	pbc     mc.Multicodec
+	lenient bool
+	unixfs  bool
 }
 
+// Multicodec returns the merkledag v1 protobuf codec. Its decoders fail on
+// links lacking a hash, a name or a size.
 func Multicodec() mc.Multicodec {
 	var n *PBNode
-	return &codec{mcproto.Multicodec(n)}
+	return &codec{pbc: mcproto.Multicodec(n)}
+}
+
+// LenientMulticodec is like Multicodec, but its decoders give the links
+// lacking a name or a size the default ones: an empty name and a zero size.
+// Links lacking a hash still fail to decode. Note that such nodes are not
+// encoded back identically, as the defaults are then written.
+func LenientMulticodec() mc.Multicodec {
+	var n *PBNode
+	return &codec{pbc: mcproto.Multicodec(n), lenient: true}
 }
 
 func (c *codec) Encoder(w io.Writer) mc.Encoder {
@@ -61,12 +79,12 @@ func (c *encoder) Encode(v interface{}) error {
 		return errors.New("must encode *ipld.Node")
 	}
 
-	if _, err := c.w.Write(c.c.Header()); err != nil {
+	n, err := ld2pbNode(nv)
+	if err != nil {
 		return err
 	}
 
-	n, err := ld2pbNode(nv)
-	if err != nil {
+	if _, err := c.w.Write(c.c.Header()); err != nil {
 		return err
 	}
 
@@ -88,7 +106,12 @@ func (c *decoder) Decode(v interface{}) error {
 		return err
 	}
 
-	pb2ldNode(&pbn, nv)
+	if err := pb2ldNode(&pbn, nv, c.c.lenient); err != nil {
+		return err
+	}
+	if c.c.unixfs {
+		decodeUnixfs(*nv)
+	}
 	return nil
 }
 
@@ -104,7 +127,13 @@ func ld2pbNode(in *ipld.Node) (*PBNode, error) {
 			return nil, errInvalidData
 		}
 	} else {
-		return &pbn, nil
+		return ld2pbTopLevel(n)
+	}
+
+	for k := range attrs {
+		if k != "data" && k != "links" && k != UnixfsKey {
+			return nil, fmt.Errorf("%s (@attrs.%s)", errInvalidKey, k)
+		}
 	}
 
 	if data, hasdata := attrs["data"]; hasdata {
@@ -115,109 +144,377 @@ func ld2pbNode(in *ipld.Node) (*PBNode, error) {
 		pbn.Data = data
 	}
 
+	if unixfs, hasunixfs := attrs[UnixfsKey]; hasunixfs {
+		if _, hasdata := attrs["data"]; hasdata {
+			return nil, fmt.Errorf("%s (both @attrs.data and @attrs.%s)", errInvalidKey, UnixfsKey)
+		}
+		data, err := ld2pbUnixfs(unixfs)
+		if err != nil {
+			return nil, err
+		}
+		pbn.Data = data
+	}
+
 	if links, haslinks := attrs["links"]; haslinks {
-		links, ok := links.([]ipld.Node)
+		links, ok := linkNodes(links)
 		if !ok {
 			return nil, errInvalidLink
 		}
 
-		for _, link := range links {
-			pblink := ld2pbLink(link)
-			if pblink == nil {
-				return nil, fmt.Errorf("%s (%s)", errInvalidLink, link["name"])
+		for i, link := range links {
+			pblink, err := ld2pbLink(link)
+			if err != nil {
+				return nil, fmt.Errorf("%s (link %d: %s)", errInvalidLink, i, err)
 			}
 			pbn.Links = append(pbn.Links, pblink)
 		}
 	}
+
+	// the top-level entries must be the ones pb2ldNode would write, or the
+	// node would not decode back identically.
+	entries := map[string][]*PBLink{}
+	for _, pbl := range pbn.Links {
+		if k, ok := linkKey(pbl.GetName()); ok {
+			entries[k] = append(entries[k], pbl)
+		}
+	}
+	for k, v := range n {
+		if k == "@attrs" {
+			continue
+		}
+		if err := checkTopLevelKey(k); err != nil {
+			return nil, err
+		} else if k == ipld.CodecKey {
+			continue
+		}
+
+		name := ipld.UnescapePathComponent(k)
+		pblinks, err := topLevelLinks(v, name, len(entries[k]) > 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(pblinks) != len(entries[k]) {
+			return nil, fmt.Errorf("%s (%s does not match @attrs.links)", errInvalidLink, name)
+		}
+		for i := range pblinks {
+			if !pblinks[i].Equal(entries[k][i]) {
+				return nil, fmt.Errorf("%s (%s does not match @attrs.links)", errInvalidLink, name)
+			}
+		}
+	}
+	for k := range entries {
+		if _, ok := n[k]; !ok {
+			return nil, fmt.Errorf("%s (%s missing from the top-level)", errInvalidLink, ipld.UnescapePathComponent(k))
+		}
+	}
 	return &pbn, nil
 }
 
-func pb2ldNode(pbn *PBNode, in *ipld.Node) {
-	*in = make(ipld.Node)
-	n := *in
+// ld2pbTopLevel encodes a node without @attrs, building its links from its
+// top-level entries, as written by pb2ldNode. Every entry but @codec must be
+// a link, either a protobuf link with a "hash", or a merkle-link, or a list
+// of links sharing the same name. The links are sorted by name.
+func ld2pbTopLevel(n ipld.Node) (*PBNode, error) {
+	var pbn PBNode
+	for k, v := range n {
+		if err := checkTopLevelKey(k); err != nil {
+			return nil, err
+		} else if k == ipld.CodecKey {
+			continue
+		}
+
+		_, isList := v.([]interface{})
+		pblinks, err := topLevelLinks(v, ipld.UnescapePathComponent(k), isList)
+		if err != nil {
+			return nil, err
+		}
+		pbn.Links = append(pbn.Links, pblinks...)
+	}
+
+	if len(pbn.Links) == 0 {
+		return nil, errEmptyNode
+	}
+	sort.Stable(byName(pbn.Links))
+	return &pbn, nil
+}
+
+// checkTopLevelKey checks that k could be a top-level key of a decoded node,
+// other than @attrs. The @codec directive is allowed, so that nodes can be
+// encoded with this codec by the mux.
+func checkTopLevelKey(k string) error {
+	if k == ipld.CodecKey {
+		return nil
+	}
+	if len(k) > 0 && k[0] == '@' {
+		return fmt.Errorf("%s (%s)", errInvalidKey, k)
+	}
+	if k2, ok := linkKey(ipld.UnescapePathComponent(k)); !ok || k2 != k {
+		return fmt.Errorf("%s (invalid link key %q)", errInvalidLink, k)
+	}
+	return nil
+}
+
+// topLevelLinks returns the links of the top-level entry for name, which is
+// a list of links if isList is set, and a single link otherwise.
+func topLevelLinks(v interface{}, name string, isList bool) ([]*PBLink, error) {
+	var entries []interface{}
+	if isList {
+		var ok bool
+		entries, ok = v.([]interface{})
+		if !ok || len(entries) < 2 {
+			return nil, fmt.Errorf("%s (%s is not a list of links)", errInvalidLink, name)
+		}
+	} else {
+		entries = []interface{}{v}
+	}
+
+	pblinks := make([]*PBLink, len(entries))
+	for i, e := range entries {
+		link, err := topLevelLink(e, name)
+		if err != nil {
+			return nil, err
+		}
+		pblinks[i], err = ld2pbLink(link)
+		if err != nil {
+			return nil, fmt.Errorf("%s (%s: %s)", errInvalidLink, name, err)
+		}
+	}
+	return pblinks, nil
+}
+
+// topLevelLink returns the link stored at the top-level entry for name, in
+// the form found in @attrs.links.
+func topLevelLink(v interface{}, name string) (ipld.Node, error) {
+	entry, ok := v.(ipld.Node)
+	if !ok {
+		return nil, fmt.Errorf("%s (%s is not a link)", errInvalidLink, name)
+	}
+
+	hash, err := linkHash(entry)
+	if err != nil {
+		return nil, fmt.Errorf("%s (%s: %s)", errInvalidLink, name, err)
+	}
+
+	if n, ok := entry["name"]; ok && n != name {
+		return nil, fmt.Errorf("%s (conflicting names %v and %s)", errInvalidLink, n, name)
+	}
+
+	size, ok := entry["size"]
+	if !ok {
+		size = uint64(0)
+	}
+	return ipld.Node{"hash": hash, "name": name, "size": size}, nil
+}
+
+// linkHash returns the hash of a link, which is held by "hash" as bytes, or
+// by "mlink" as a merkle-link, or both if they agree.
+func linkHash(link ipld.Node) ([]byte, error) {
+	hash, hasHash := link["hash"].([]byte)
+	if _, ok := link["hash"]; ok && !hasHash {
+		return nil, errors.New("hash not bytes")
+	}
+
+	if v, ok := link[ipld.LinkKey]; ok {
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.New("mlink not a string")
+		}
+		if hasHash {
+			if s != mh.Multihash(hash).B58String() {
+				return nil, errors.New("conflicting hash and mlink")
+			}
+		} else {
+			h, err := mh.FromB58String(s)
+			if err != nil {
+				return nil, err
+			}
+			hash, hasHash = h, true
+		}
+	}
+
+	if !hasHash {
+		return nil, errors.New("missing hash")
+	}
+	return hash, nil
+}
+
+// linkKey returns the top-level key of the links named name. Names which
+// cannot be path components, the empty one and the ones containing a "/",
+// have no key: such links are only found in @attrs.links.
+func linkKey(name string) (string, bool) {
+	if name == "" || strings.Contains(name, "/") {
+		return "", false
+	}
+	return ipld.EscapePathComponent(name), true
+}
+
+type byName []*PBLink
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byName) Less(i, j int) bool { return s[i].GetName() < s[j].GetName() }
+
+func pb2ldNode(pbn *PBNode, in *ipld.Node, lenient bool) error {
+	n := make(ipld.Node)
 
 	links := make([]ipld.Node, len(pbn.Links))
 	for i, link := range pbn.Links {
-		links[i] = pb2ldLink(link)
-		n[ipld.EscapePathComponent(link.GetName())] = links[i]
+		var err error
+		links[i], err = pb2ldLink(link, lenient)
+		if err != nil {
+			return fmt.Errorf("%s (link %d: %s)", errInvalidLink, i, err)
+		}
+	}
+
+	// the links are also written at the top-level, by name. The links sharing
+	// a name are written as a list, in the order of @attrs.links.
+	names := map[string][]interface{}{}
+	for i, link := range pbn.Links {
+		if k, ok := linkKey(link.GetName()); ok {
+			names[k] = append(names[k], links[i])
+		}
+	}
+	for k, entries := range names {
+		if len(entries) == 1 {
+			n[k] = entries[0]
+		} else {
+			n[k] = entries
+		}
 	}
 
 	n["@attrs"] = ipld.Node{
 		"links": links,
 		"data": pbn.Data,
 	}
+	*in = n
+	return nil
 }
 
-func pb2ldLink(pbl *PBLink) (link ipld.Node) {
-	defer func() {
-		if recover() != nil {
-			link = nil
-		}
-	}()
+// pb2ldLink converts a link. Links lacking a name or a size are invalid,
+// unless lenient is set, in which case they get the default ones.
+func pb2ldLink(pbl *PBLink, lenient bool) (ipld.Node, error) {
+	if pbl.Hash == nil {
+		return nil, errors.New("missing hash")
+	}
+	if !lenient && pbl.Name == nil {
+		return nil, errors.New("missing name")
+	}
+	if !lenient && pbl.Tsize == nil {
+		return nil, errors.New("missing size")
+	}
 
-	link = make(ipld.Node)
+	link := make(ipld.Node)
+	link[ipld.LinkKey] = mh.Multihash(pbl.Hash).B58String()
 	link["hash"] = pbl.Hash
-	link["name"] = *pbl.Name
-	link["size"] = uint64(*pbl.Tsize)
-	return link
+	link["name"] = pbl.GetName()
+	link["size"] = pbl.GetTsize()
+	return link, nil
 }
 
-func ld2pbLink(link ipld.Node) (pbl *PBLink) {
-	defer func() {
-		if recover() != nil {
-			pbl = nil
-		}
-	}()
-
-	hash := link["hash"].([]byte)
-	name := link["name"].(string)
-	size := link["size"].(uint64)
+func ld2pbLink(link ipld.Node) (*PBLink, error) {
+	hash, err := linkHash(link)
+	if err != nil {
+		return nil, err
+	}
+	name, ok := link["name"].(string)
+	if !ok {
+		return nil, errors.New("name not a string")
+	}
+	size, ok := Uint64Value(link["size"])
+	if !ok {
+		return nil, errors.New("invalid size")
+	}
 
-	pbl = &PBLink{}
+	pbl := &PBLink{}
 	pbl.Hash = hash
 	pbl.Name = &name
 	pbl.Tsize = &size
-	return pbl
+	return pbl, nil
 }
 
-func IsOldProtobufNode(n ipld.Node) bool {
-	if len(n) > 2 { // short circuit
-		return false
+// linkNodes returns the links of @attrs. They are a []ipld.Node when decoded
+// by this codec, but a []interface{} when decoded by the other codecs.
+func linkNodes(v interface{}) ([]ipld.Node, bool) {
+	switch links := v.(type) {
+	case []ipld.Node:
+		return links, true
+	case []interface{}:
+		res := make([]ipld.Node, len(links))
+		for i, l := range links {
+			n, ok := l.(ipld.Node)
+			if !ok {
+				return nil, false
+			}
+			res[i] = n
+		}
+		return res, true
 	}
+	return nil, false
+}
 
-	links, hasLinks := n["links"]
-	_, hasData := n["data"]
+// Uint64Value returns the value of a size, such as the size of a link. It is
+// an uint64 when decoded by this codec, but an int64 when decoded by the other
+// codecs.
+func Uint64Value(v interface{}) (uint64, bool) {
+	switch size := v.(type) {
+	case uint64:
+		return size, true
+	case int64:
+		if size >= 0 {
+			return uint64(size), true
+		}
+	}
+	return 0, false
+}
 
-	switch len(n) {
-	case 2: // must be links and data
-		if !hasLinks || !hasData {
-			return false
+// IsOldProtobufNode returns whether n has the shape of a node decoded by this
+// codec: @attrs holds only the data, links or UnixFS fields of the node, with
+// the expected types, and every other top-level entry is a link or a list of
+// links. The node may still fail to encode, if its links are inconsistent
+// for instance.
+func IsOldProtobufNode(n ipld.Node) bool {
+	attrs, ok := n["@attrs"].(ipld.Node)
+	if !ok {
+		return false
+	}
+	for k, v := range attrs {
+		switch k {
+		case "data":
+			_, ok = v.([]byte)
+		case "links":
+			_, ok = linkNodes(v)
+		case UnixfsKey:
+			_, ok = v.(ipld.Node)
+		default:
+			ok = false
 		}
-	case 1: // must be links or data
-		if !(hasLinks || hasData) {
+		if !ok {
 			return false
 		}
-	default: // nope.
-		return false
 	}
 
-	if len(n) > 2 {
-		return false // only links and data.
-	}
-
-	if hasLinks {
-		links, ok := links.([]ipld.Node)
-		if !ok {
-			return false // invalid links.
+	for k, v := range n {
+		if k == "@attrs" || k == ipld.CodecKey {
+			continue
+		}
+		if checkTopLevelKey(k) != nil || !isLinkEntry(v) {
+			return false
 		}
+	}
+	return true
+}
 
-		// every link must be a mlink
-		for _, link := range links {
-			if !ipld.IsLink(link) {
+// isLinkEntry returns whether v could be a top-level entry of a decoded node:
+// a link, or a list of links sharing the same name.
+func isLinkEntry(v interface{}) bool {
+	if l, ok := v.([]interface{}); ok {
+		for _, e := range l {
+			if _, ok := e.(ipld.Node); !ok {
 				return false
 			}
 		}
+		return len(l) > 1
 	}
-
-	return true // ok looks like an old protobuf node
+	_, ok := v.(ipld.Node)
+	return ok
 }
@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"encoding/hex"
 	"io/ioutil"
+	"strings"
 	"testing"
 	"reflect"
 
 	mc "github.com/jbenet/go-multicodec"
 	mcproto "github.com/jbenet/go-multicodec/protobuf"
+	mh "github.com/jbenet/go-multihash"
 
 	ipld "github.com/ipfs/go-ipld"
+	codectest "github.com/ipfs/go-ipld/coding/codectest"
 )
 
 var testfile []byte
@@ -156,3 +159,293 @@ func TestLD2PB(t *testing.T) {
 		t.Fatal("decoded bytes != encoded bytes")
 	}
 }
+
+func TestLD2PBTopLevelLinks(t *testing.T) {
+	var n ipld.Node
+	if err := mc.Unmarshal(Multicodec(), testfile, &n); err != nil {
+		t.Fatal("failed to decode", err)
+	}
+
+	// only keep the links: they are written at the top-level too
+	attrs := n["@attrs"].(ipld.Node)
+	delete(n, "@attrs")
+
+	var pbn PBNode
+	encoded, err := mc.Marshal(Multicodec(), &n)
+	if err != nil {
+		t.Fatal("failed to encode", err)
+	}
+	if err := mc.Unmarshal(mcproto.Multicodec(&pbn), encoded[len(Header):], &pbn); err != nil {
+		t.Fatal("failed to decode", err)
+	}
+
+	links := attrs["links"].([]ipld.Node)
+	if len(pbn.Links) != len(links) {
+		t.Fatalf("expected %d links, got %d", len(links), len(pbn.Links))
+	}
+	for i, l := range links {
+		if l2, err := pb2ldLink(pbn.Links[i], false); err != nil || !reflect.DeepEqual(l2, l) {
+			t.Errorf("link %d: expected %v, got %v", i, l, pbn.Links[i])
+		}
+	}
+
+	// merkle-links can be used too
+	hash := []byte("\x12\x20" + "0123456789abcdef0123456789abcdef")
+	mlink := ipld.Node{ipld.LinkKey: mh.Multihash(hash).B58String()}
+	n = ipld.Node{ipld.CodecKey: "/mdagv1", "\\@bar": mlink, "foo": mlink}
+	encoded, err = mc.Marshal(Multicodec(), &n)
+	if err != nil {
+		t.Fatal("failed to encode", err)
+	}
+	if err := mc.Unmarshal(mcproto.Multicodec(&pbn), encoded[len(Header):], &pbn); err != nil {
+		t.Fatal("failed to decode", err)
+	}
+	if len(pbn.Links) != 2 || pbn.Links[0].GetName() != "@bar" || pbn.Links[1].GetName() != "foo" ||
+		!bytes.Equal(pbn.Links[1].Hash, hash) || pbn.Links[1].GetTsize() != 0 {
+		t.Errorf("unexpected links: %v", pbn.Links)
+	}
+
+	// @codec is allowed along with @attrs too
+	if err := mc.Unmarshal(Multicodec(), testfile, &n); err != nil {
+		t.Fatal("failed to decode", err)
+	}
+	n[ipld.CodecKey] = "/mdagv1"
+	encoded, err = mc.Marshal(Multicodec(), &n)
+	if err != nil {
+		t.Fatal("failed to encode with @codec", err)
+	}
+	if !bytes.HasPrefix(testfile, encoded) {
+		t.Error("@codec changed the encoding")
+	}
+
+	for _, n := range []ipld.Node{
+		{},
+		{"@context": "foo"},
+		{"@context": "foo", "foo": mlink},
+		{"foo/bar": mlink},
+		{"a\\b": mlink},
+		{"foo": "not a link"},
+		{"foo": ipld.Node{"hash": hash, "name": "bar", "size": uint64(0)}},
+		{"foo": ipld.Node{ipld.LinkKey: "QmZku7P7KeeHAnwMr6c4HveYfMzmtVinNXzibkiNbfDbPo", "hash": hash}},
+		{"foo": ipld.Node{"hash": hash, "size": "not a size"}},
+	} {
+		if _, err := mc.Marshal(Multicodec(), &n); err == nil {
+			t.Errorf("encoded %v", n)
+		}
+	}
+}
+
+func TestMalformedLinks(t *testing.T) {
+	hash := []byte("\x12\x20" + "0123456789abcdef0123456789abcdef")
+	mlink := mh.Multihash(hash).B58String()
+	name := "foo"
+	size := uint64(12)
+
+	encode := func(links ...*PBLink) []byte {
+		pbn := &PBNode{Links: links, Data: []byte("data")}
+		encoded, err := mc.Marshal(mcproto.Multicodec(pbn), pbn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(append([]byte(nil), Header...), encoded...)
+	}
+	valid := &PBLink{Hash: hash, Name: &name, Tsize: &size}
+
+	for _, tc := range []struct {
+		link    *PBLink
+		missing string
+		lenient ipld.Node // nil if the lenient codec fails too
+	}{
+		{&PBLink{Name: &name, Tsize: &size}, "hash", nil},
+		{&PBLink{Hash: hash, Tsize: &size}, "name", ipld.Node{ipld.LinkKey: mlink, "hash": hash, "name": "", "size": size}},
+		{&PBLink{Hash: hash, Name: &name}, "size", ipld.Node{ipld.LinkKey: mlink, "hash": hash, "name": name, "size": uint64(0)}},
+		{&PBLink{}, "hash", nil},
+	} {
+		encoded := encode(valid, tc.link)
+
+		var n ipld.Node
+		err := mc.Unmarshal(Multicodec(), encoded, &n)
+		if err == nil || !strings.Contains(err.Error(), "link 1: missing "+tc.missing) {
+			t.Errorf("missing %s: unexpected error %v", tc.missing, err)
+		}
+
+		err = mc.Unmarshal(LenientMulticodec(), encoded, &n)
+		if tc.lenient == nil {
+			if err == nil {
+				t.Errorf("missing %s: lenient codec decoded the link", tc.missing)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("missing %s: %s", tc.missing, err)
+			continue
+		}
+		links := n["@attrs"].(ipld.Node)["links"].([]ipld.Node)
+		if len(links) != 2 || !reflect.DeepEqual(links[1], tc.lenient) {
+			t.Errorf("missing %s: unexpected links %v", tc.missing, links)
+		}
+		for _, l := range links {
+			if l == nil {
+				t.Errorf("missing %s: nil link decoded", tc.missing)
+			}
+		}
+	}
+}
+
+func TestLinkNames(t *testing.T) {
+	h1 := []byte("\x12\x20" + "0123456789abcdef0123456789abcdef")
+	h2 := []byte("\x12\x20" + "fedcba9876543210fedcba9876543210")
+	link := func(hash []byte, name string) *PBLink {
+		size := uint64(len(name))
+		return &PBLink{Hash: hash, Name: &name, Tsize: &size}
+	}
+
+	pbn := &PBNode{Links: []*PBLink{
+		link(h1, "foo"),
+		link(h1, "@attrs"),
+		link(h2, "foo"),
+		link(h1, ""),
+		link(h2, "a/b"),
+	}}
+	encoded, err := mc.Marshal(mcproto.Multicodec(pbn), pbn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded = append(append([]byte(nil), Header...), encoded...)
+
+	var n ipld.Node
+	if err := mc.Unmarshal(Multicodec(), encoded, &n); err != nil {
+		t.Fatal(err)
+	}
+
+	links := n["@attrs"].(ipld.Node)["links"].([]ipld.Node)
+	if len(links) != 5 {
+		t.Fatalf("expected 5 links in @attrs, got %d", len(links))
+	}
+	foo, ok := n["foo"].([]interface{})
+	if !ok || len(foo) != 2 || !reflect.DeepEqual(foo[0], links[0]) || !reflect.DeepEqual(foo[1], links[2]) {
+		t.Errorf("links sharing a name not listed in order: %v", n["foo"])
+	}
+	if !reflect.DeepEqual(n["\\@attrs"], links[1]) {
+		t.Errorf("link named @attrs not escaped: %v", n["\\@attrs"])
+	}
+	if len(n) != 3 {
+		t.Errorf("unexpected top-level entries: %v", n)
+	}
+
+	encoded2, err := mc.Marshal(Multicodec(), &n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, encoded2) {
+		t.Error("node not encoded back identically")
+	}
+
+	// nodes which would not decode back identically are refused
+	for _, change := range []func(n ipld.Node){
+		func(n ipld.Node) { n["foo"] = foo[0] },
+		func(n ipld.Node) { n["foo"] = []interface{}{foo[1], foo[0]} },
+		func(n ipld.Node) { delete(n, "\\@attrs") },
+		func(n ipld.Node) { n["bar"] = foo[0] },
+		func(n ipld.Node) { n[""] = links[3] },
+		func(n ipld.Node) { n["@context"] = "foo" },
+		func(n ipld.Node) { n["@attrs"].(ipld.Node)["foo"] = "bar" },
+	} {
+		n2 := ipld.Node{}
+		for k, v := range n {
+			n2[k] = v
+		}
+		n2["@attrs"] = ipld.Node{"links": links}
+		change(n2)
+
+		if _, err := mc.Marshal(Multicodec(), &n2); err == nil {
+			t.Errorf("encoded %v", n2)
+		}
+	}
+}
+
+func TestIPLDLinks(t *testing.T) {
+	var n ipld.Node
+	if err := mc.Unmarshal(Multicodec(), testfile, &n); err != nil {
+		t.Fatal("failed to decode", err)
+	}
+	if !IsOldProtobufNode(n) {
+		t.Error("decoded node not recognized as a protobuf node")
+	}
+
+	links := n["@attrs"].(ipld.Node)["links"].([]ipld.Node)
+	found := n.Links()
+	if len(found) != len(links) {
+		t.Fatalf("expected %d links, got %d", len(links), len(found))
+	}
+	for _, l := range links {
+		name := l["name"].(string)
+		link, ok := found[name]
+		if !ok {
+			t.Errorf("link %s not found", name)
+			continue
+		}
+
+		h, err := link.Hash()
+		if err != nil {
+			t.Errorf("link %s: %s", name, err)
+		} else if !bytes.Equal(h, l["hash"].([]byte)) {
+			t.Errorf("link %s: hash differs from the protobuf one", name)
+		}
+		if link["name"] != name || link["size"] != l["size"] {
+			t.Errorf("link %s: properties not preserved: %v", name, link)
+		}
+	}
+
+	// the merkle-link must agree with the hash
+	l := n["Makefile"].(ipld.Node)
+	l[ipld.LinkKey] = "QmZku7P7KeeHAnwMr6c4HveYfMzmtVinNXzibkiNbfDbPo"
+	if _, err := mc.Marshal(Multicodec(), &n); err == nil {
+		t.Error("encoded a link with conflicting hashes")
+	}
+	if !IsOldProtobufNode(n) {
+		t.Error("node with conflicting hashes not recognized as a protobuf node")
+	}
+	for _, n := range []ipld.Node{
+		{"data": []byte("data")},
+		{"@attrs": ipld.Node{"@container": "@list"}},
+		{"@attrs": ipld.Node{"data": "not bytes"}},
+		{"@attrs": ipld.Node{"links": "not links"}},
+		{"@attrs": ipld.Node{"data": []byte("data")}, "title": "not a link"},
+		{"@attrs": ipld.Node{"data": []byte("data")}, "@type": ipld.Node{}},
+	} {
+		if IsOldProtobufNode(n) {
+			t.Errorf("%v recognized as a protobuf node", n)
+		}
+	}
+}
+
+func TestConformance(t *testing.T) {
+	hash := []byte("\x12\x20" + "0123456789abcdef0123456789abcdef")
+	mlink := mh.Multihash(hash).B58String()
+	codectest.Run(t, codectest.Config{
+		Codec: Multicodec(),
+		Nodes: map[string]ipld.Node{
+			"empty": {
+				"@attrs": ipld.Node{},
+			},
+			"data": {
+				"@attrs": ipld.Node{
+					"data": []byte("\x00\x01binary\xff"),
+				},
+			},
+			"links": {
+				"@attrs": ipld.Node{
+					"data": []byte{},
+					"links": []ipld.Node{
+						{ipld.LinkKey: mlink, "hash": hash, "name": "foo", "size": uint64(12)},
+						{ipld.LinkKey: mlink, "hash": hash, "name": "@bar", "size": uint64(0)},
+					},
+				},
+				"foo":    ipld.Node{ipld.LinkKey: mlink, "hash": hash, "name": "foo", "size": uint64(12)},
+				"\\@bar": ipld.Node{ipld.LinkKey: mlink, "hash": hash, "name": "@bar", "size": uint64(0)},
+			},
+		},
+		FixturesDir: "testdata",
+	})
+}
@@ -0,0 +1,184 @@
+package ipldpb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	proto "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
+	mc "github.com/jbenet/go-multicodec"
+	mcproto "github.com/jbenet/go-multicodec/protobuf"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+// UnixfsKey is the key of @attrs holding the UnixFS data of a node, in place
+// of its opaque data.
+const UnixfsKey = "unixfs"
+
+var errInvalidUnixfs = fmt.Errorf("invalid merkledag v1 protobuf, invalid UnixFS data")
+
+// UnixfsMulticodec is like Multicodec, but its decoders also decode the Data
+// of the nodes holding UnixFS data, such as the files and directories added
+// to IPFS. Their @attrs hold the UnixFS fields under "unixfs", instead of the
+// opaque "data":
+//
+//	"@attrs": {
+//		"unixfs": {
+//			"type": "file",
+//			"data": <bytes>,
+//			"filesize": 262158,
+//			"blocksizes": [262144, 14],
+//		},
+//		"links": [...],
+//	}
+//
+// The type is one of "raw", "directory", "file", "metadata" and "symlink".
+// The other fields are only present if they are in the UnixFS data.
+//
+// The Data which is not UnixFS data, or which would not be encoded back
+// identically, is left opaque. All the merkledag codecs encode the nodes
+// holding UnixFS fields, so that such nodes can be built and then encoded.
+func UnixfsMulticodec() mc.Multicodec {
+	var n *PBNode
+	return &codec{pbc: mcproto.Multicodec(n), unixfs: true}
+}
+
+// unixfsTypes are the names of the UnixFS types in nodes.
+var unixfsTypes = map[Data_DataType]string{
+	Data_Raw:       "raw",
+	Data_Directory: "directory",
+	Data_File:      "file",
+	Data_Metadata:  "metadata",
+	Data_Symlink:   "symlink",
+}
+
+// pb2ldUnixfs returns the UnixFS fields held by data, if it is UnixFS data
+// which ld2pbUnixfs encodes back identically.
+func pb2ldUnixfs(data []byte) (ipld.Node, bool) {
+	if data == nil {
+		return nil, false
+	}
+
+	var pbd Data
+	if err := proto.Unmarshal(data, &pbd); err != nil || pbd.XXX_unrecognized != nil {
+		return nil, false
+	}
+	typ, ok := unixfsTypes[pbd.GetType()]
+	if !ok {
+		return nil, false
+	}
+
+	n := ipld.Node{"type": typ}
+	if pbd.Data != nil {
+		n["data"] = pbd.Data
+	}
+	if pbd.Filesize != nil {
+		n["filesize"] = *pbd.Filesize
+	}
+	if pbd.Blocksizes != nil {
+		sizes := make([]interface{}, len(pbd.Blocksizes))
+		for i, s := range pbd.Blocksizes {
+			sizes[i] = s
+		}
+		n["blocksizes"] = sizes
+	}
+
+	encoded, err := ld2pbUnixfs(n)
+	if err != nil || !bytes.Equal(encoded, data) {
+		return nil, false
+	}
+	return n, true
+}
+
+// ld2pbUnixfs returns the UnixFS data holding the fields of n.
+func ld2pbUnixfs(v interface{}) ([]byte, error) {
+	n, ok := v.(ipld.Node)
+	if !ok {
+		return nil, fmt.Errorf("%s (not a node)", errInvalidUnixfs)
+	}
+
+	var pbd Data
+	for k, v := range n {
+		var err error
+		switch k {
+		case "type":
+			pbd.Type, err = unixfsType(v)
+		case "data":
+			data, ok := v.([]byte)
+			if !ok {
+				err = errors.New("data not bytes")
+			}
+			pbd.Data = data
+		case "filesize":
+			size, ok := Uint64Value(v)
+			if !ok {
+				err = errors.New("invalid filesize")
+			}
+			pbd.Filesize = &size
+		case "blocksizes":
+			pbd.Blocksizes, err = unixfsBlocksizes(v)
+		default:
+			err = fmt.Errorf("unexpected key %s", k)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s (%s)", errInvalidUnixfs, err)
+		}
+	}
+
+	if pbd.Type == nil {
+		return nil, fmt.Errorf("%s (missing type)", errInvalidUnixfs)
+	}
+	return proto.Marshal(&pbd)
+}
+
+func unixfsType(v interface{}) (*Data_DataType, error) {
+	name, ok := v.(string)
+	if ok {
+		for t, n := range unixfsTypes {
+			if n == name {
+				return t.Enum(), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("type not one of %s", strings.Join(unixfsTypeNames(), ", "))
+}
+
+func unixfsTypeNames() []string {
+	names := make([]string, len(unixfsTypes))
+	for t, n := range unixfsTypes {
+		names[t] = n
+	}
+	return names
+}
+
+// unixfsBlocksizes returns the sizes of the blocks of a file. They are a
+// []interface{} of uint64 when decoded by this codec, but of int64 when
+// decoded by the other codecs.
+func unixfsBlocksizes(v interface{}) ([]uint64, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.New("blocksizes not a list")
+	}
+
+	sizes := make([]uint64, len(list))
+	for i, s := range list {
+		sizes[i], ok = Uint64Value(s)
+		if !ok {
+			return nil, fmt.Errorf("invalid blocksize %d", i)
+		}
+	}
+	return sizes, nil
+}
+
+// decodeUnixfs replaces the data of a node decoded by pb2ldNode with its
+// UnixFS fields, if it holds UnixFS data.
+func decodeUnixfs(n ipld.Node) {
+	attrs := n["@attrs"].(ipld.Node)
+	data, _ := attrs["data"].([]byte)
+	if unixfs, ok := pb2ldUnixfs(data); ok {
+		delete(attrs, "data")
+		attrs[UnixfsKey] = unixfs
+	}
+}
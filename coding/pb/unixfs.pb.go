@@ -0,0 +1,98 @@
+// Code generated by protoc-gen-gogo.
+// source: unixfs.proto
+// DO NOT EDIT!
+
+package ipldpb
+
+import proto "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = math.Inf
+
+type Data_DataType int32
+
+const (
+	Data_Raw       Data_DataType = 0
+	Data_Directory Data_DataType = 1
+	Data_File      Data_DataType = 2
+	Data_Metadata  Data_DataType = 3
+	Data_Symlink   Data_DataType = 4
+)
+
+var Data_DataType_name = map[int32]string{
+	0: "Raw",
+	1: "Directory",
+	2: "File",
+	3: "Metadata",
+	4: "Symlink",
+}
+var Data_DataType_value = map[string]int32{
+	"Raw":       0,
+	"Directory": 1,
+	"File":      2,
+	"Metadata":  3,
+	"Symlink":   4,
+}
+
+func (x Data_DataType) Enum() *Data_DataType {
+	p := new(Data_DataType)
+	*p = x
+	return p
+}
+func (x Data_DataType) String() string {
+	return proto.EnumName(Data_DataType_name, int32(x))
+}
+func (x *Data_DataType) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(Data_DataType_value, data, "Data_DataType")
+	if err != nil {
+		return err
+	}
+	*x = Data_DataType(value)
+	return nil
+}
+
+type Data struct {
+	Type             *Data_DataType `protobuf:"varint,1,req,enum=ipldpb.Data_DataType" json:"Type,omitempty"`
+	Data             []byte         `protobuf:"bytes,2,opt" json:"Data,omitempty"`
+	Filesize         *uint64        `protobuf:"varint,3,opt,name=filesize" json:"filesize,omitempty"`
+	Blocksizes       []uint64       `protobuf:"varint,4,rep,name=blocksizes" json:"blocksizes,omitempty"`
+	XXX_unrecognized []byte         `json:"-"`
+}
+
+func (m *Data) Reset()         { *m = Data{} }
+func (m *Data) String() string { return proto.CompactTextString(m) }
+func (*Data) ProtoMessage()    {}
+
+func (m *Data) GetType() Data_DataType {
+	if m != nil && m.Type != nil {
+		return *m.Type
+	}
+	return Data_Raw
+}
+
+func (m *Data) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *Data) GetFilesize() uint64 {
+	if m != nil && m.Filesize != nil {
+		return *m.Filesize
+	}
+	return 0
+}
+
+func (m *Data) GetBlocksizes() []uint64 {
+	if m != nil {
+		return m.Blocksizes
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterEnum("ipldpb.Data_DataType", Data_DataType_name, Data_DataType_value)
+}
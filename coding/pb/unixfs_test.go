@@ -0,0 +1,135 @@
+package ipldpb
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	mc "github.com/jbenet/go-multicodec"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+func TestUnixfsDirectory(t *testing.T) {
+	var n ipld.Node
+	if err := mc.Unmarshal(UnixfsMulticodec(), testfile, &n); err != nil {
+		t.Fatal("failed to decode", err)
+	}
+
+	attrs := n["@attrs"].(ipld.Node)
+	if _, ok := attrs["data"]; ok {
+		t.Error("UnixFS data left opaque")
+	}
+	if !reflect.DeepEqual(attrs[UnixfsKey], ipld.Node{"type": "directory"}) {
+		t.Errorf("unexpected UnixFS fields: %v", attrs[UnixfsKey])
+	}
+
+	// the node is encoded back identically, by any merkledag codec
+	for _, c := range []mc.Multicodec{UnixfsMulticodec(), Multicodec()} {
+		encoded, err := mc.Marshal(c, &n)
+		if err != nil {
+			t.Fatal("failed to encode", err)
+		}
+		if !bytes.Equal(encoded, testfile) {
+			t.Error("round trip changed the node")
+		}
+	}
+	if !IsOldProtobufNode(n) {
+		t.Error("decoded node not recognized as a protobuf node")
+	}
+}
+
+func TestUnixfsFile(t *testing.T) {
+	hash := []byte("\x12\x20" + "0123456789abcdef0123456789abcdef")
+	link := ipld.Node{"hash": hash, "name": "", "size": uint64(262158)}
+	file := ipld.Node{
+		"@attrs": ipld.Node{
+			UnixfsKey: ipld.Node{
+				"type":     "file",
+				"data":     []byte("head"),
+				"filesize": int64(262148),
+				// as decoded by the other codecs
+				"blocksizes": []interface{}{int64(262144)},
+			},
+			"links": []interface{}{link},
+		},
+	}
+
+	encoded, err := mc.Marshal(Multicodec(), &file)
+	if err != nil {
+		t.Fatal("failed to encode", err)
+	}
+
+	// decoded opaque by default
+	var n ipld.Node
+	if err := mc.Unmarshal(Multicodec(), encoded, &n); err != nil {
+		t.Fatal("failed to decode", err)
+	}
+	data, ok := n["@attrs"].(ipld.Node)["data"].([]byte)
+	if !ok || !bytes.Equal(data, []byte("\x08\x02\x12\x04head\x18\x84\x80\x10\x20\x80\x80\x10")) {
+		t.Errorf("unexpected data: %q", data)
+	}
+
+	if err := mc.Unmarshal(UnixfsMulticodec(), encoded, &n); err != nil {
+		t.Fatal("failed to decode", err)
+	}
+	expected := ipld.Node{
+		"type":       "file",
+		"data":       []byte("head"),
+		"filesize":   uint64(262148),
+		"blocksizes": []interface{}{uint64(262144)},
+	}
+	if unixfs := n["@attrs"].(ipld.Node)[UnixfsKey]; !reflect.DeepEqual(unixfs, expected) {
+		t.Errorf("unexpected UnixFS fields: %v", unixfs)
+	}
+}
+
+func TestUnixfsOpaque(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		[]byte("not protobuf \xff"),
+		[]byte("\x12\x04head"),         // no type
+		[]byte("\x08\x07"),             // unknown type
+		[]byte("\x08\x02\x28\x01"),     // unknown field
+		[]byte("\x12\x04head\x08\x02"), // fields out of order
+	} {
+		encoded, err := mc.Marshal(Multicodec(), &ipld.Node{"@attrs": ipld.Node{"data": data}})
+		if err != nil {
+			t.Fatal("failed to encode", err)
+		}
+
+		var n ipld.Node
+		if err := mc.Unmarshal(UnixfsMulticodec(), encoded, &n); err != nil {
+			t.Fatal("failed to decode", err)
+		}
+		attrs := n["@attrs"].(ipld.Node)
+		if _, ok := attrs[UnixfsKey]; ok || !bytes.Equal(attrs["data"].([]byte), data) {
+			t.Errorf("%q: data not left opaque: %v", data, attrs)
+		}
+	}
+}
+
+func TestInvalidUnixfs(t *testing.T) {
+	for _, unixfs := range []interface{}{
+		"file",
+		ipld.Node{},
+		ipld.Node{"type": "hamt"},
+		ipld.Node{"type": "file", "data": "not bytes"},
+		ipld.Node{"type": "file", "filesize": int64(-1)},
+		ipld.Node{"type": "file", "blocksizes": []interface{}{"1"}},
+		ipld.Node{"type": "file", "mode": int64(0644)},
+	} {
+		n := ipld.Node{"@attrs": ipld.Node{UnixfsKey: unixfs}}
+		if _, err := mc.Marshal(UnixfsMulticodec(), &n); err == nil {
+			t.Errorf("encoded invalid UnixFS fields %v", unixfs)
+		}
+	}
+
+	n := ipld.Node{"@attrs": ipld.Node{
+		"data":    []byte{},
+		UnixfsKey: ipld.Node{"type": "raw"},
+	}}
+	if _, err := mc.Marshal(UnixfsMulticodec(), &n); err == nil {
+		t.Error("encoded both opaque data and UnixFS fields")
+	}
+}
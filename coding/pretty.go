@@ -0,0 +1,166 @@
+package ipfsld
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+
+	mc "github.com/jbenet/go-multicodec"
+	mcjson "github.com/jbenet/go-multicodec/json"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+var errJsonIndent = errors.New("JSON indent must be made of spaces and tabs")
+
+// PrettyJsonMulticodec is like JsonMulticodec, but it pretty prints the nodes
+// for humans to read them, for instance in code reviews. The output is
+// stable: map keys are sorted, each map entry and list item is on its own
+// line, indented with indent (usually spaces or a tab), and links and byte
+// strings are kept on a single line:
+//
+//	{
+//	  "data": {"@bytes": "AAFiaW5hcnn/"},
+//	  "foo": {"mlink": "QmZku7P7KeeHAnwMr6c4HveYfMzmtVinNXzibkiNbfDbPo"}
+//	}
+//
+// The output is still JSON with the /json header: it decodes back to the same
+// node with any JSON codec.
+func PrettyJsonMulticodec(indent string) mc.Multicodec {
+	return &transformCodec{&jsonCodec{mcjson.Multicodec(false), indent}}
+}
+
+// writePrettyJson writes v, as returned by jsonValue, followed by a newline.
+func writePrettyJson(w io.Writer, v interface{}, indent string) error {
+	if strings.Trim(indent, " \t") != "" {
+		return errJsonIndent
+	}
+
+	var buf bytes.Buffer
+	if err := prettyJson(&buf, v, indent, 0); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func prettyJson(buf *bytes.Buffer, v interface{}, indent string, depth int) error {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		if len(vv) == 0 || isInlineJson(vv) {
+			return inlineJson(buf, vv)
+		}
+
+		keys := make([]string, 0, len(vv))
+		for k := range vv {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			newline(buf, indent, depth+1)
+			if err := inlineJson(buf, k); err != nil {
+				return err
+			}
+			buf.WriteString(": ")
+			if err := prettyJson(buf, vv[k], indent, depth+1); err != nil {
+				return err
+			}
+		}
+		newline(buf, indent, depth)
+		buf.WriteByte('}')
+		return nil
+
+	case []interface{}:
+		if len(vv) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+
+		buf.WriteByte('[')
+		for i, c := range vv {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			newline(buf, indent, depth+1)
+			if err := prettyJson(buf, c, indent, depth+1); err != nil {
+				return err
+			}
+		}
+		newline(buf, indent, depth)
+		buf.WriteByte(']')
+		return nil
+
+	default:
+		return inlineJson(buf, v)
+	}
+}
+
+// isInlineJson returns whether m is a link or a byte string which only holds
+// scalars, and so is printed on a single line.
+func isInlineJson(m map[string]interface{}) bool {
+	if _, ok := m[ipld.LinkKey].(string); !ok {
+		if _, ok := jsonBytes(m); !ok {
+			return false
+		}
+	}
+
+	for _, v := range m {
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			return false
+		}
+	}
+	return true
+}
+
+// inlineJson writes v on a single line. Map keys are sorted, and separated
+// from their values by a space, like in the pretty printed maps.
+func inlineJson(buf *bytes.Buffer, v interface{}) error {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		return nil
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		if err := inlineJson(buf, k); err != nil {
+			return err
+		}
+		buf.WriteString(": ")
+		if err := inlineJson(buf, m[k]); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func newline(buf *bytes.Buffer, indent string, depth int) {
+	buf.WriteByte('\n')
+	for i := 0; i < depth; i++ {
+		buf.WriteString(indent)
+	}
+}
@@ -0,0 +1,69 @@
+package ipfsld
+
+import (
+	"reflect"
+	"testing"
+
+	ipld "github.com/ipfs/go-ipld"
+
+	mc "github.com/jbenet/go-multicodec"
+)
+
+func TestPrettyJson(t *testing.T) {
+	n := ipld.Node{
+		ipld.CodecKey: "/json",
+		"foo":         "bar",
+		"data":        []byte("\x00\x01binary\xff"),
+		"empty":       ipld.Node{},
+		"list":        []interface{}{int64(1), 2.5, []interface{}{}},
+		"link": ipld.Node{
+			ipld.LinkKey: "QmZku7P7KeeHAnwMr6c4HveYfMzmtVinNXzibkiNbfDbPo",
+			"size":       int64(12),
+		},
+		"nested": ipld.Node{"a": ipld.Node{"b": nil}},
+	}
+
+	expected := `{
+  "@codec": "/json",
+  "data": {"@bytes": "AAFiaW5hcnn/"},
+  "empty": {},
+  "foo": "bar",
+  "link": {"mlink": "QmZku7P7KeeHAnwMr6c4HveYfMzmtVinNXzibkiNbfDbPo", "size": 12},
+  "list": [
+    1,
+    2.5,
+    []
+  ],
+  "nested": {
+    "a": {
+      "b": null
+    }
+  }
+}
+`
+
+	encoded, err := mc.Marshal(PrettyJsonMulticodec("  "), &n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr := JsonMulticodec().Header()
+	if string(encoded) != string(hdr)+expected {
+		t.Errorf("unexpected output:\n%s", encoded)
+	}
+
+	for _, codec := range []mc.Multicodec{JsonMulticodec(), PrettyJsonMulticodec("\t")} {
+		var n2 ipld.Node
+		if err := mc.Unmarshal(codec, encoded, &n2); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(n, n2) {
+			t.Logf("Expected: %#v", n)
+			t.Logf("Actual:   %#v", n2)
+			t.Error("pretty JSON did not decode to the same node")
+		}
+	}
+
+	if _, err := mc.Marshal(PrettyJsonMulticodec("--"), &n); err != errJsonIndent {
+		t.Errorf("expected errJsonIndent, got %v", err)
+	}
+}
@@ -0,0 +1,458 @@
+// Package ipldprotobuf maps arbitrary protobuf messages to IPLD nodes, so
+// that blocks holding them can be decoded and encoded like any other node.
+package ipldprotobuf
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strings"
+
+	proto "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
+	mc "github.com/jbenet/go-multicodec"
+	mh "github.com/jbenet/go-multihash"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+// HeaderPrefix is the prefix of the header paths of the protobuf codecs,
+// which are followed by the full name of their message type.
+const HeaderPrefix = "/protobuf/"
+
+var (
+	errUnknownFields = errors.New("protobuf message has unknown fields")
+	errNotNode       = errors.New("protobuf message must be a node")
+)
+
+// Multicodec returns the codec of the protobuf messages of type name, which
+// must be registered with proto.RegisterType, as generated code does. Its
+// header path is HeaderPrefix followed by name, such as
+// "/protobuf/example.Message", and it can be registered with
+// coding.RegisterCodec so that the decoded nodes can be encoded back.
+//
+// Messages are mapped to nodes by field name, the name of the field in the
+// .proto file if the generated code records it. Fields which are not set are
+// absent from the node, repeated fields are lists, and nested messages are
+// nodes. Numbers follow the same model as the other codecs: integers are
+// int64, or uint64 if they do not fit, enums are integers too, and floats are
+// float64. Decoded nodes also have the @codec of the codec.
+//
+// The bytes fields listed in links hold multihashes, which are mapped to
+// merkle-links. They are named by their path from the message, the fields of
+// nested messages following the name of the message field, such as
+// "parent" or "entries/hash".
+//
+// Messages with unknown fields, which would not be encoded back identically,
+// fail to decode. Oneof and map fields are not supported.
+func Multicodec(name string, links ...string) (mc.Multicodec, error) {
+	t := proto.MessageType(name)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("protobuf message type %s not registered", name)
+	}
+
+	m, err := newMessage(t.Elem(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf message type %s: %s", name, err)
+	}
+	for _, l := range links {
+		if err := m.setLink(strings.Split(l, "/")); err != nil {
+			return nil, fmt.Errorf("protobuf message type %s: link %s: %s", name, l, err)
+		}
+	}
+
+	hdrPath := HeaderPrefix + name
+	return &codec{mc.Header([]byte(hdrPath)), hdrPath, t.Elem(), m}, nil
+}
+
+type codec struct {
+	header  []byte
+	hdrPath string
+	typ     reflect.Type
+	msg     *message
+}
+
+type encoder struct {
+	w io.Writer
+	c *codec
+}
+
+type decoder struct {
+	r io.Reader
+	c *codec
+}
+
+func (c *codec) Header() []byte {
+	return c.header
+}
+
+func (c *codec) Encoder(w io.Writer) mc.Encoder {
+	return &encoder{w, c}
+}
+
+func (c *codec) Decoder(r io.Reader) mc.Decoder {
+	return &decoder{r, c}
+}
+
+func (e *encoder) Encode(v interface{}) error {
+	n, ok := v.(*ipld.Node)
+	if !ok {
+		return errors.New("must encode *ipld.Node")
+	}
+
+	if codec, ok := (*n)[ipld.CodecKey]; ok && codec != e.c.hdrPath {
+		return fmt.Errorf("cannot encode a node with %s %v", ipld.CodecKey, codec)
+	}
+	pv := reflect.New(e.c.typ)
+	if err := e.c.msg.fromNode(*n, pv.Elem(), true); err != nil {
+		return err
+	}
+	data, err := proto.Marshal(pv.Interface().(proto.Message))
+	if err != nil {
+		return err
+	}
+
+	if err := mc.WriteHeader(e.w, e.c.header); err != nil {
+		return err
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (d *decoder) Decode(v interface{}) error {
+	n, ok := v.(*ipld.Node)
+	if !ok {
+		return errors.New("must decode to *ipld.Node")
+	}
+
+	if err := mc.ConsumeHeader(d.r, d.c.header); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(d.r)
+	if err != nil {
+		return err
+	}
+
+	pv := reflect.New(d.c.typ)
+	if err := proto.Unmarshal(data, pv.Interface().(proto.Message)); err != nil {
+		return err
+	}
+	res, err := d.c.msg.toNode(pv.Elem())
+	if err != nil {
+		return err
+	}
+	res[ipld.CodecKey] = d.c.hdrPath
+	*n = res
+	return nil
+}
+
+// message describes how a message type is mapped to nodes.
+type message struct {
+	fields []*field
+	byName map[string]*field
+
+	unrecognized int // index of the XXX_unrecognized field, or -1
+}
+
+// field describes a field of a message type.
+type field struct {
+	name     string
+	index    int
+	repeated bool
+	kind     reflect.Kind // of a single value
+	link     bool         // bytes field mapped to a merkle-link
+	msg      *message     // for message fields
+}
+
+// newMessage returns the mapping of the message type t. The message types
+// being converted are in seen, so that recursive types are mapped once.
+func newMessage(t reflect.Type, seen map[reflect.Type]*message) (*message, error) {
+	if m, ok := seen[t]; ok {
+		return m, nil
+	}
+	if seen == nil {
+		seen = map[reflect.Type]*message{}
+	}
+	m := &message{byName: map[string]*field{}, unrecognized: -1}
+	seen[t] = m
+
+	props := proto.GetProperties(t)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Name == "XXX_unrecognized" {
+			m.unrecognized = i
+			continue
+		} else if strings.HasPrefix(sf.Name, "XXX_") {
+			continue
+		}
+		if sf.Tag.Get("protobuf_oneof") != "" {
+			return nil, fmt.Errorf("oneof field %s not supported", sf.Name)
+		}
+		if sf.Tag.Get("protobuf") == "" {
+			continue
+		}
+
+		f := &field{name: props.Prop[i].OrigName, index: i, repeated: props.Prop[i].Repeated}
+		if f.name == "" {
+			f.name = sf.Name
+		}
+
+		ft := sf.Type
+		if f.repeated && ft.Kind() == reflect.Slice {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		f.kind = ft.Kind()
+		switch f.kind {
+		case reflect.Struct:
+			var err error
+			if f.msg, err = newMessage(ft, seen); err != nil {
+				return nil, err
+			}
+		case reflect.Map:
+			return nil, fmt.Errorf("map field %s not supported", f.name)
+		}
+
+		m.fields = append(m.fields, f)
+		m.byName[f.name] = f
+	}
+	return m, nil
+}
+
+// setLink marks the bytes field at path as a link. The mapping of a nested
+// message is shared by all the fields of its type, so it is copied before
+// being marked: the link is only set for this path.
+func (m *message) setLink(path []string) error {
+	f, ok := m.byName[path[0]]
+	if !ok {
+		return fmt.Errorf("no field %s", path[0])
+	}
+	if len(path) > 1 {
+		if f.msg == nil {
+			return fmt.Errorf("field %s is not a message", f.name)
+		}
+		f.msg = f.msg.copy()
+		return f.msg.setLink(path[1:])
+	}
+	if f.kind != reflect.Slice {
+		return fmt.Errorf("field %s is not bytes", f.name)
+	}
+	f.link = true
+	return nil
+}
+
+// copy returns a copy of m, whose fields can be modified. The mappings of
+// the nested messages are still shared.
+func (m *message) copy() *message {
+	c := &message{byName: make(map[string]*field, len(m.fields)), unrecognized: m.unrecognized}
+	for _, f := range m.fields {
+		fc := *f
+		c.fields = append(c.fields, &fc)
+		c.byName[fc.name] = &fc
+	}
+	return c
+}
+
+// toNode returns the node representing the message v.
+func (m *message) toNode(v reflect.Value) (ipld.Node, error) {
+	if m.unrecognized >= 0 && v.Field(m.unrecognized).Len() > 0 {
+		return nil, errUnknownFields
+	}
+
+	n := ipld.Node{}
+	for _, f := range m.fields {
+		fv := v.Field(f.index)
+		if isUnset(fv) {
+			continue
+		}
+
+		if !f.repeated {
+			val, err := f.toValue(fv)
+			if err != nil {
+				return nil, err
+			}
+			n[f.name] = val
+			continue
+		}
+
+		list := make([]interface{}, fv.Len())
+		for i := range list {
+			val, err := f.toValue(fv.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			list[i] = val
+		}
+		n[f.name] = list
+	}
+	return n, nil
+}
+
+// isUnset returns whether the field value v is not set: a nil pointer or
+// slice, or the zero value of the scalars of proto3 messages, which are not
+// encoded.
+func isUnset(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Slice:
+		return v.IsNil()
+	case reflect.Struct:
+		return false
+	}
+	return v.Interface() == reflect.Zero(v.Type()).Interface()
+}
+
+// toValue returns the value representing a single value of field f.
+func (f *field) toValue(v reflect.Value) (interface{}, error) {
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if f.msg != nil {
+		return f.msg.toNode(v)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint32, reflect.Uint64:
+		if u := v.Uint(); u > math.MaxInt64 {
+			return u, nil
+		}
+		return int64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Slice:
+		b := v.Bytes()
+		if !f.link {
+			return b, nil
+		}
+		if _, err := mh.Cast(b); err != nil {
+			return nil, fmt.Errorf("field %s: invalid link: %s", f.name, err)
+		}
+		return ipld.Node{ipld.LinkKey: mh.Multihash(b).B58String()}, nil
+	}
+	return nil, fmt.Errorf("field %s: unsupported type %s", f.name, v.Type())
+}
+
+// fromNode sets the fields of the message v from the node n. The @codec of
+// the root message is skipped, as it was checked by the encoder.
+func (m *message) fromNode(n ipld.Node, v reflect.Value, root bool) error {
+	for k, val := range n {
+		if root && k == ipld.CodecKey {
+			continue
+		}
+		f, ok := m.byName[k]
+		if !ok {
+			return fmt.Errorf("unexpected key %s", k)
+		}
+
+		fv := v.Field(f.index)
+		if !f.repeated {
+			if err := f.fromValue(val, fv); err != nil {
+				return err
+			}
+			continue
+		}
+
+		list, ok := val.([]interface{})
+		if !ok {
+			return fmt.Errorf("field %s: not a list", f.name)
+		}
+		fv.Set(reflect.MakeSlice(fv.Type(), len(list), len(list)))
+		for i, item := range list {
+			if err := f.fromValue(item, fv.Index(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fromValue sets v, a single value of field f, from val.
+func (f *field) fromValue(val interface{}, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		v.Set(reflect.New(v.Type().Elem()))
+		v = v.Elem()
+	}
+
+	if f.msg != nil {
+		n, ok := val.(ipld.Node)
+		if !ok {
+			return fmt.Errorf("field %s: %s", f.name, errNotNode)
+		}
+		return f.msg.fromNode(n, v, false)
+	}
+
+	ok := false
+	switch v.Kind() {
+	case reflect.Bool:
+		var b bool
+		if b, ok = val.(bool); ok {
+			v.SetBool(b)
+		}
+	case reflect.Int32, reflect.Int64:
+		var i int64
+		if i, ok = val.(int64); ok && !v.OverflowInt(i) {
+			v.SetInt(i)
+		} else {
+			ok = false
+		}
+	case reflect.Uint32, reflect.Uint64:
+		var u uint64
+		switch i := val.(type) {
+		case int64:
+			u, ok = uint64(i), i >= 0
+		case uint64:
+			u, ok = i, true
+		}
+		if ok && !v.OverflowUint(u) {
+			v.SetUint(u)
+		} else {
+			ok = false
+		}
+	case reflect.Float32, reflect.Float64:
+		var fl float64
+		if fl, ok = val.(float64); ok {
+			v.SetFloat(fl)
+		}
+	case reflect.String:
+		var s string
+		if s, ok = val.(string); ok {
+			v.SetString(s)
+		}
+	case reflect.Slice:
+		if f.link {
+			return f.fromLink(val, v)
+		}
+		var b []byte
+		if b, ok = val.([]byte); ok {
+			v.SetBytes(b)
+		}
+	}
+
+	if !ok {
+		return fmt.Errorf("field %s: invalid value %v", f.name, val)
+	}
+	return nil
+}
+
+// fromLink sets the bytes value v from the link val.
+func (f *field) fromLink(val interface{}, v reflect.Value) error {
+	n, ok := val.(ipld.Node)
+	if !ok || len(n) != 1 || !ipld.IsLink(n) {
+		return fmt.Errorf("field %s: not a link", f.name)
+	}
+	h, err := ipld.Link(n).Hash()
+	if err != nil {
+		return fmt.Errorf("field %s: invalid link: %s", f.name, err)
+	}
+	v.SetBytes(h)
+	return nil
+}
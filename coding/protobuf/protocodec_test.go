@@ -0,0 +1,330 @@
+package ipldprotobuf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	proto "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
+	mc "github.com/jbenet/go-multicodec"
+	mh "github.com/jbenet/go-multihash"
+
+	ipld "github.com/ipfs/go-ipld"
+	coding "github.com/ipfs/go-ipld/coding"
+)
+
+// testMessage and testEntry are written like the code generated for:
+//
+//	message Message {
+//		optional string title = 1;
+//		optional int32 count = 2;
+//		optional uint64 size = 3;
+//		optional double ratio = 4;
+//		optional bool done = 5;
+//		optional bytes data = 6;
+//		repeated string tags = 7;
+//		optional bytes parent = 8;
+//		repeated Entry entries = 9;
+//	}
+//
+//	message Entry {
+//		optional string name = 1;
+//		optional bytes hash = 2;
+//	}
+type testMessage struct {
+	Title            *string      `protobuf:"bytes,1,opt,name=title"`
+	Count            *int32       `protobuf:"varint,2,opt,name=count"`
+	Size             *uint64      `protobuf:"varint,3,opt,name=size"`
+	Ratio            *float64     `protobuf:"fixed64,4,opt,name=ratio"`
+	Done             *bool        `protobuf:"varint,5,opt,name=done"`
+	Data             []byte       `protobuf:"bytes,6,opt,name=data"`
+	Tags             []string     `protobuf:"bytes,7,rep,name=tags"`
+	Parent           []byte       `protobuf:"bytes,8,opt,name=parent"`
+	Entries          []*testEntry `protobuf:"bytes,9,rep,name=entries"`
+	XXX_unrecognized []byte
+}
+
+func (m *testMessage) Reset()         { *m = testMessage{} }
+func (m *testMessage) String() string { return proto.CompactTextString(m) }
+func (*testMessage) ProtoMessage()    {}
+
+type testEntry struct {
+	Name             *string `protobuf:"bytes,1,opt,name=name"`
+	Hash             []byte  `protobuf:"bytes,2,opt,name=hash"`
+	XXX_unrecognized []byte
+}
+
+func (m *testEntry) Reset()         { *m = testEntry{} }
+func (m *testEntry) String() string { return proto.CompactTextString(m) }
+func (*testEntry) ProtoMessage()    {}
+
+// testPair has two fields of the same message type.
+type testPair struct {
+	A                *testEntry `protobuf:"bytes,1,opt,name=a"`
+	B                *testEntry `protobuf:"bytes,2,opt,name=b"`
+	XXX_unrecognized []byte
+}
+
+func (m *testPair) Reset()         { *m = testPair{} }
+func (m *testPair) String() string { return proto.CompactTextString(m) }
+func (*testPair) ProtoMessage()    {}
+
+// testNode and testLink are written like the code generated by older
+// versions of protoc-gen-gogo, whose tags do not record the field names, as
+// for the merkledag protobuf nodes.
+type testNode struct {
+	Links            []*testLink `protobuf:"bytes,2,rep" json:"Links,omitempty"`
+	Data             []byte      `protobuf:"bytes,1,opt" json:"Data,omitempty"`
+	XXX_unrecognized []byte      `json:"-"`
+}
+
+func (m *testNode) Reset()         { *m = testNode{} }
+func (m *testNode) String() string { return proto.CompactTextString(m) }
+func (*testNode) ProtoMessage()    {}
+
+type testLink struct {
+	Hash             []byte  `protobuf:"bytes,1,opt" json:"Hash,omitempty"`
+	Name             *string `protobuf:"bytes,2,opt" json:"Name,omitempty"`
+	Tsize            *uint64 `protobuf:"varint,3,opt" json:"Tsize,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *testLink) Reset()         { *m = testLink{} }
+func (m *testLink) String() string { return proto.CompactTextString(m) }
+func (*testLink) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterType((*testMessage)(nil), "ipldprotobuf.test.Message")
+	proto.RegisterType((*testEntry)(nil), "ipldprotobuf.test.Entry")
+	proto.RegisterType((*testPair)(nil), "ipldprotobuf.test.Pair")
+	proto.RegisterType((*testNode)(nil), "ipldprotobuf.test.Node")
+	proto.RegisterType((*testLink)(nil), "ipldprotobuf.test.Link")
+}
+
+var (
+	parentHash = mh.Multihash("\x12\x20" + "0123456789abcdef0123456789abcdef")
+	entryHash  = mh.Multihash("\x12\x20" + "fedcba9876543210fedcba9876543210")
+)
+
+func testCodec(t *testing.T) mc.Multicodec {
+	c, err := Multicodec("ipldprotobuf.test.Message", "parent", "entries/hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestMessage(t *testing.T) {
+	c := testCodec(t)
+	if hdr := string(mc.HeaderPath(c.Header())); hdr != "/protobuf/ipldprotobuf.test.Message" {
+		t.Errorf("unexpected header %s", hdr)
+	}
+
+	msg := &testMessage{
+		Title:  proto.String("title"),
+		Count:  proto.Int32(-3),
+		Size:   proto.Uint64(1 << 63),
+		Ratio:  proto.Float64(0.5),
+		Done:   proto.Bool(false),
+		Data:   []byte("\x00data\xff"),
+		Tags:   []string{"a", "b"},
+		Parent: parentHash,
+		Entries: []*testEntry{
+			{Name: proto.String("entry"), Hash: entryHash},
+			{},
+		},
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := append(c.Header(), data...)
+
+	var n ipld.Node
+	if err := mc.Unmarshal(c, encoded, &n); err != nil {
+		t.Fatal(err)
+	}
+	expected := ipld.Node{
+		ipld.CodecKey: "/protobuf/ipldprotobuf.test.Message",
+		"title":       "title",
+		"count":       int64(-3),
+		"size":        uint64(1 << 63),
+		"ratio":       0.5,
+		"done":        false,
+		"data":        []byte("\x00data\xff"),
+		"tags":        []interface{}{"a", "b"},
+		"parent":      ipld.Node{ipld.LinkKey: parentHash.B58String()},
+		"entries": []interface{}{
+			ipld.Node{"name": "entry", "hash": ipld.Node{ipld.LinkKey: entryHash.B58String()}},
+			ipld.Node{},
+		},
+	}
+	if !reflect.DeepEqual(n, expected) {
+		t.Errorf("decoded as %#v", n)
+	}
+
+	links := n.Links()
+	if len(links) != 2 || links["parent"].LinkStr() != parentHash.B58String() || links["entries/0/hash"].LinkStr() != entryHash.B58String() {
+		t.Errorf("unexpected links: %v", links)
+	}
+
+	reencoded, err := mc.Marshal(c, &n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reencoded, encoded) {
+		t.Errorf("round trip changed the message: %q", reencoded)
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	c := testCodec(t)
+	if err := coding.RegisterCodec(c); err != nil {
+		t.Fatal(err)
+	}
+	defer coding.UnregisterCodec(c)
+
+	// the mux selects the codec with @codec
+	n := ipld.Node{
+		ipld.CodecKey: "/protobuf/ipldprotobuf.test.Message",
+		"title":       "title",
+		"parent":      ipld.Node{ipld.LinkKey: parentHash.B58String()},
+	}
+	encoded, err := mc.Marshal(coding.Multicodec(), &n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(encoded, coding.Multicodec().Header()) {
+		t.Fatalf("unexpected encoding: %q", encoded)
+	}
+
+	var n2 ipld.Node
+	if err := mc.Unmarshal(coding.Multicodec(), encoded, &n2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(n, n2) {
+		t.Errorf("decoded as %#v", n2)
+	}
+}
+
+func TestGeneratedMessage(t *testing.T) {
+	c, err := Multicodec("ipldprotobuf.test.Node", "Links/Hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := "foo"
+	size := uint64(12)
+	data, err := proto.Marshal(&testNode{
+		Links: []*testLink{{Hash: parentHash, Name: &name, Tsize: &size}},
+		Data:  []byte("data"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n ipld.Node
+	if err := mc.Unmarshal(c, append(c.Header(), data...), &n); err != nil {
+		t.Fatal(err)
+	}
+	expected := ipld.Node{
+		ipld.CodecKey: "/protobuf/ipldprotobuf.test.Node",
+		"Data":        []byte("data"),
+		"Links": []interface{}{
+			ipld.Node{"Hash": ipld.Node{ipld.LinkKey: parentHash.B58String()}, "Name": "foo", "Tsize": int64(12)},
+		},
+	}
+	if !reflect.DeepEqual(n, expected) {
+		t.Errorf("decoded as %#v", n)
+	}
+}
+
+func TestLinkPaths(t *testing.T) {
+	c, err := Multicodec("ipldprotobuf.test.Pair", "a/hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the field on the path is a link, not the one of the same type
+	data, err := proto.Marshal(&testPair{
+		A: &testEntry{Hash: parentHash},
+		B: &testEntry{Hash: []byte("not a hash")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n ipld.Node
+	if err := mc.Unmarshal(c, append(c.Header(), data...), &n); err != nil {
+		t.Fatal(err)
+	}
+	expected := ipld.Node{
+		ipld.CodecKey: "/protobuf/ipldprotobuf.test.Pair",
+		"a":           ipld.Node{"hash": ipld.Node{ipld.LinkKey: parentHash.B58String()}},
+		"b":           ipld.Node{"hash": []byte("not a hash")},
+	}
+	if !reflect.DeepEqual(n, expected) {
+		t.Errorf("decoded as %#v", n)
+	}
+
+	// the entries of other codecs are not marked either
+	c, err = Multicodec("ipldprotobuf.test.Message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = proto.Marshal(&testMessage{Entries: []*testEntry{{Hash: []byte("bytes")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mc.Unmarshal(c, append(c.Header(), data...), &n); err != nil {
+		t.Fatal(err)
+	} else if _, ok := n["entries"].([]interface{})[0].(ipld.Node)["hash"].([]byte); !ok {
+		t.Errorf("decoded as %#v", n)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, args := range [][]string{
+		{"ipldprotobuf.test.Missing"},
+		{"ipldprotobuf.test.Message", "missing"},
+		{"ipldprotobuf.test.Message", "title"},
+		{"ipldprotobuf.test.Message", "entries"},
+		{"ipldprotobuf.test.Message", "title/hash"},
+	} {
+		if _, err := Multicodec(args[0], args[1:]...); err == nil {
+			t.Errorf("created codec %v", args)
+		}
+	}
+
+	c := testCodec(t)
+	for _, n := range []ipld.Node{
+		{ipld.CodecKey: "/cbor"},
+		{"missing": "foo"},
+		{"title": int64(1)},
+		{"count": int64(1 << 40)},
+		{"size": int64(-1)},
+		{"ratio": int64(1)},
+		{"tags": "a"},
+		{"parent": parentHash.B58String()},
+		{"parent": ipld.Node{ipld.LinkKey: "not a hash"}},
+		{"parent": ipld.Node{ipld.LinkKey: parentHash.B58String(), "size": int64(1)}},
+		{"entries": []interface{}{"not a node"}},
+		{"entries": []interface{}{ipld.Node{"missing": "foo"}}},
+	} {
+		if _, err := mc.Marshal(c, &n); err == nil {
+			t.Errorf("encoded invalid node %v", n)
+		}
+	}
+
+	// unknown fields would be lost, and links must be multihashes
+	for _, data := range []string{
+		"\x50\x01",              // field 10
+		"\x4a\x02\x18\x01",      // field 3 of an entry
+		"\x42\x03not",           // invalid link
+		"\x0a\x05title\x12\x01", // truncated
+	} {
+		var n ipld.Node
+		if err := mc.Unmarshal(c, append(c.Header(), data...), &n); err == nil {
+			t.Errorf("decoded invalid message %q", data)
+		}
+	}
+}
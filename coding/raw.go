@@ -0,0 +1,117 @@
+package ipfsld
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	mc "github.com/jbenet/go-multicodec"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+// rawHeader identifies the raw codec. Unlike the other codecs, the raw codec
+// usually does not write its header: raw blocks are exactly their content, so
+// that their hash is the hash of the content.
+var rawHeader = mc.Header([]byte("/raw"))
+
+var errRawNode = errors.New("raw codec can only encode raw nodes")
+
+// RawNode returns a raw leaf node holding data. It is encoded as data itself
+// by Multicodec(), and its content is returned by ipld.Node.RawData.
+func RawNode(data []byte) ipld.Node {
+	return ipld.Node{
+		ipld.CodecKey: string(mc.HeaderPath(rawHeader)),
+		ipld.RawKey:   data,
+	}
+}
+
+// RawMulticodec returns the codec of raw leaf nodes, as returned by RawNode.
+// It encodes them as their content, with no header. It decodes anything: the
+// whole input is the content of the node, minus a leading /raw header.
+//
+// Multicodec() does not wrap raw nodes in its header either, and decodes as a
+// raw node every object which does not start with a multicodec header: a
+// length byte, followed by as many bytes, the last one being a newline. Empty
+// input, which it decodes as io.EOF, and content starting with such a header
+// or being the start of the header of Multicodec() would be ambiguous: such
+// content is encoded after the /raw header instead, and wrapped in the header
+// of Multicodec() by Multicodec(). These blocks are then not exactly their
+// content, and their hash is not the hash of the content.
+func RawMulticodec() mc.Multicodec {
+	return rawCodec{}
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Header() []byte {
+	return rawHeader
+}
+
+func (rawCodec) Encoder(w io.Writer) mc.Encoder {
+	return &rawEncoder{w}
+}
+
+func (rawCodec) Decoder(r io.Reader) mc.Decoder {
+	return &rawDecoder{r}
+}
+
+type rawEncoder struct {
+	w io.Writer
+}
+
+func (e *rawEncoder) Encode(v interface{}) error {
+	vn, ok := v.(*ipld.Node)
+	if !ok {
+		return errRawNode
+	}
+
+	// anything but the codec would be lost
+	data, ok := vn.RawData()
+	if !ok {
+		return errRawNode
+	}
+	for k := range *vn {
+		if k != ipld.RawKey && k != ipld.CodecKey {
+			return errRawNode
+		}
+	}
+	if isAmbiguousRaw(data) {
+		if err := mc.WriteHeader(e.w, rawHeader); err != nil {
+			return err
+		}
+	}
+
+	_, err := e.w.Write(data)
+	return err
+}
+
+type rawDecoder struct {
+	r io.Reader
+}
+
+func (d *rawDecoder) Decode(v interface{}) error {
+	vn, ok := v.(*ipld.Node)
+	if !ok {
+		return errRawNode
+	}
+
+	data, err := ioutil.ReadAll(d.r)
+	if err != nil {
+		return err
+	}
+	*vn = RawNode(bytes.TrimPrefix(data, rawHeader))
+	return nil
+}
+
+// isAmbiguousRaw returns whether the raw content data cannot be encoded as is,
+// as it would not decode as a raw node.
+func isAmbiguousRaw(data []byte) bool {
+	return len(data) == 0 || hasMulticodecHeader(data) || muxCodec.isTruncatedHeader(data)
+}
+
+// isRawCodec returns whether c is the raw codec, possibly wrapped.
+func isRawCodec(c mc.Multicodec) bool {
+	return bytes.Equal(c.Header(), rawHeader)
+}
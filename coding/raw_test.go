@@ -0,0 +1,141 @@
+package ipfsld
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	ipld "github.com/ipfs/go-ipld"
+
+	mc "github.com/jbenet/go-multicodec"
+)
+
+func TestRawNode(t *testing.T) {
+	for _, data := range [][]byte{
+		[]byte("file chunk"),
+		[]byte("\x00\x01binary\xff"),
+		[]byte("/json\n"),
+	} {
+		n := RawNode(data)
+		encoded, err := mc.Marshal(Multicodec(), &n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded, data) {
+			t.Errorf("raw node %q encoded as %q", data, encoded)
+		}
+
+		for _, codec := range []mc.Multicodec{Multicodec(), StrictMulticodec(), LimitedMulticodec(Limits{MaxTotalSize: 1024})} {
+			var n2 ipld.Node
+			if err := mc.Unmarshal(codec, encoded, &n2); err != nil {
+				t.Errorf("%q: %s", data, err)
+				continue
+			}
+			if d, ok := n2.RawData(); !ok || !bytes.Equal(d, data) {
+				t.Errorf("%q decoded as %#v", data, n2)
+			}
+			if n2[ipld.CodecKey] != "/raw" {
+				t.Errorf("%q: decoded node has %s %v", data, ipld.CodecKey, n2[ipld.CodecKey])
+			}
+		}
+	}
+
+	// content which looks like a header is encoded after the /raw header
+	for _, data := range [][]byte{
+		append(Multicodec().Header(), "data"...),
+		[]byte("\x06/cbor\ndata"),
+		[]byte("\x05abcd\nline"),
+		[]byte("\x05/raw\ndata"),
+		Multicodec().Header()[:5],
+		{},
+	} {
+		n := RawNode(data)
+		encoded, err := mc.Marshal(Multicodec(), &n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := append(append(Multicodec().Header(), RawMulticodec().Header()...), data...)
+		if !bytes.Equal(encoded, expected) {
+			t.Errorf("raw node %q encoded as %q", data, encoded)
+		}
+
+		for _, codec := range []mc.Multicodec{Multicodec(), StrictMulticodec(), LimitedMulticodec(Limits{MaxTotalSize: 1024})} {
+			var n2 ipld.Node
+			if err := mc.Unmarshal(codec, encoded, &n2); err != nil {
+				t.Errorf("%q: %s", data, err)
+			} else if d, ok := n2.RawData(); !ok || !bytes.Equal(d, data) {
+				t.Errorf("%q decoded as %#v", data, n2)
+			}
+		}
+
+		// and so by the raw codec itself
+		encoded, err = mc.Marshal(RawMulticodec(), &n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n2 ipld.Node
+		if err := mc.Unmarshal(RawMulticodec(), encoded, &n2); err != nil {
+			t.Fatal(err)
+		}
+		if d, ok := n2.RawData(); !ok || !bytes.Equal(d, data) {
+			t.Errorf("%q decoded by the raw codec as %#v", data, n2)
+		}
+	}
+
+	// structured nodes are still decoded with their codec
+	n := ipld.Node{"foo": "bar"}
+	encoded, err := mc.Marshal(Multicodec(), &n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n2 ipld.Node
+	if err := mc.Unmarshal(Multicodec(), encoded, &n2); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := n2.RawData(); ok || n2["foo"] != "bar" {
+		t.Errorf("node decoded as %#v", n2)
+	}
+}
+
+func TestRawNodeErrors(t *testing.T) {
+	for _, n := range []ipld.Node{
+		{ipld.CodecKey: "/raw", ipld.RawKey: "not bytes"},
+		{ipld.CodecKey: "/raw", ipld.RawKey: []byte("data"), "foo": "bar"},
+	} {
+		if _, err := mc.Marshal(Multicodec(), &n); err == nil {
+			t.Errorf("encoded invalid raw node %#v", n)
+		}
+	}
+}
+
+func TestRawFallback(t *testing.T) {
+	// empty input is the end of the objects, not an empty raw node
+	var empty ipld.Node
+	if err := Multicodec().Decoder(bytes.NewReader(nil)).Decode(&empty); err != io.EOF {
+		t.Errorf("expected %v decoding empty input, got %v (%#v)", io.EOF, err, empty)
+	}
+
+	n := ipld.Node{"foo": "bar"}
+	encoded, err := mc.Marshal(Multicodec(), &n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// truncated objects fail to decode, as soon as they cannot be raw data
+	for i := 2; i < len(encoded); i++ {
+		var n2 ipld.Node
+		if err := mc.Unmarshal(Multicodec(), encoded[:i], &n2); err == nil {
+			t.Errorf("decoded the first %d bytes as %#v", i, n2)
+		}
+	}
+
+	// and so do the objects with the header of another codec
+	cbor, err := mc.Marshal(CborMulticodec(), &n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n2 ipld.Node
+	if err := mc.Unmarshal(Multicodec(), cbor, &n2); err == nil {
+		t.Errorf("decoded an object without the mux header as %#v", n2)
+	}
+}
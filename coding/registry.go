@@ -0,0 +1,79 @@
+package ipfsld
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	mc "github.com/jbenet/go-multicodec"
+)
+
+// registry holds the codecs known to Multicodec(), in registration order.
+var registry struct {
+	sync.RWMutex
+	codecs []mc.Multicodec
+}
+
+// RegisterCodec adds a codec to the ones used by Multicodec(). Nodes can then
+// select it with their @codec key, and blocks starting with its header can be
+// decoded. Codecs should be registered before Multicodec() is used, typically
+// in an init function. It is an error to register two codecs with the same
+// header.
+func RegisterCodec(c mc.Multicodec) error {
+	registry.Lock()
+	defer registry.Unlock()
+
+	for _, c2 := range registry.codecs {
+		if bytes.Equal(c.Header(), c2.Header()) {
+			return fmt.Errorf("codec %s already registered", mc.HeaderPath(c.Header()))
+		}
+	}
+
+	codecs := make([]mc.Multicodec, len(registry.codecs), len(registry.codecs)+1)
+	copy(codecs, registry.codecs)
+	registry.codecs = append(codecs, c)
+	return nil
+}
+
+// UnregisterCodec removes the registered codec with the same header as c, if
+// any. It is mostly useful to tests registering codecs of their own.
+func UnregisterCodec(c mc.Multicodec) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	codecs := make([]mc.Multicodec, 0, len(registry.codecs))
+	for _, c2 := range registry.codecs {
+		if !bytes.Equal(c.Header(), c2.Header()) {
+			codecs = append(codecs, c2)
+		}
+	}
+	registry.codecs = codecs
+}
+
+// LookupByHeader returns the registered codec with the given multicodec
+// header, or nil.
+func LookupByHeader(hdr []byte) mc.Multicodec {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	for _, c := range registry.codecs {
+		if bytes.Equal(hdr, c.Header()) {
+			return c
+		}
+	}
+	return nil
+}
+
+// LookupByName returns the registered codec whose header path is name (for
+// instance "/cbor"), or nil.
+func LookupByName(name string) mc.Multicodec {
+	return LookupByHeader(mc.Header([]byte(name)))
+}
+
+// List returns all the registered codecs, in registration order.
+func List() []mc.Multicodec {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	return append([]mc.Multicodec(nil), registry.codecs...)
+}
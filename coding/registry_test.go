@@ -0,0 +1,140 @@
+package ipfsld
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	ipld "github.com/ipfs/go-ipld"
+
+	mc "github.com/jbenet/go-multicodec"
+)
+
+// testCodec is a third-party codec: JSON with its own header.
+type testCodec struct{}
+
+var testCodecHeader = mc.Header([]byte("/test/json"))
+
+func (testCodec) Header() []byte { return testCodecHeader }
+
+func (testCodec) Encoder(w io.Writer) mc.Encoder { return testEncoder{w} }
+
+func (testCodec) Decoder(r io.Reader) mc.Decoder { return testDecoder{r} }
+
+type testEncoder struct{ w io.Writer }
+
+func (e testEncoder) Encode(v interface{}) error {
+	if err := mc.WriteHeader(e.w, testCodecHeader); err != nil {
+		return err
+	}
+	return json.NewEncoder(e.w).Encode(v)
+}
+
+type testDecoder struct{ r io.Reader }
+
+func (d testDecoder) Decode(v interface{}) error {
+	if err := mc.ConsumeHeader(d.r, testCodecHeader); err != nil {
+		return err
+	}
+	if err := json.NewDecoder(d.r).Decode(v); err != nil {
+		return err
+	}
+	_, err := convert(v)
+	return err
+}
+
+func TestRegistry(t *testing.T) {
+	for _, name := range []string{"/cbor", "/json", "/yaml", "/msgpack", "/mdagv1", "/git", "/raw"} {
+		if LookupByName(name) == nil {
+			t.Errorf("default codec %s is not registered", name)
+		}
+	}
+	if err := RegisterCodec(CborMulticodec()); err == nil {
+		t.Error("registered a codec twice")
+	}
+
+	c := testCodec{}
+	if err := RegisterCodec(c); err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterCodec(c)
+	if LookupByName("/test/json") != c || LookupByHeader(testCodecHeader) != c {
+		t.Error("registered codec not found")
+	}
+	if l := List(); l[len(l)-1] != c {
+		t.Error("registered codec not listed")
+	}
+
+	n := ipld.Node{
+		ipld.CodecKey: "/test/json",
+		"foo":         "bar",
+	}
+	encoded, err := mc.Marshal(Multicodec(), &n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(encoded, append(Multicodec().Header(), testCodecHeader...)) {
+		t.Errorf("registered codec not used by Multicodec: %q", encoded)
+	}
+
+	var n2 ipld.Node
+	if err := mc.Unmarshal(Multicodec(), encoded, &n2); err != nil {
+		t.Fatal(err)
+	}
+	if n2["foo"] != "bar" || n2[ipld.CodecKey] != "/test/json" {
+		t.Errorf("registered codec not used to decode: %#v", n2)
+	}
+}
+
+func TestUnregisterCodec(t *testing.T) {
+	c := testCodec{}
+	if err := RegisterCodec(c); err != nil {
+		t.Fatal(err)
+	}
+	UnregisterCodec(c)
+	if LookupByName("/test/json") != nil {
+		t.Error("unregistered codec still registered")
+	}
+	for _, c2 := range List() {
+		if c2 == c {
+			t.Error("unregistered codec still listed")
+		}
+	}
+
+	n := ipld.Node{
+		ipld.CodecKey: "/test/json",
+		"foo":         "bar",
+	}
+	if _, err := mc.Marshal(Multicodec(), &n); err == nil {
+		t.Error("unregistered codec used by Multicodec")
+	}
+}
+
+func TestRegisterWhileEncoding(t *testing.T) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			c := testCodec{}
+			if err := RegisterCodec(c); err != nil {
+				t.Error(err)
+				return
+			}
+			UnregisterCodec(c)
+		}
+	}()
+
+	n := ipld.Node{"foo": "bar"}
+	for i := 0; i < 100; i++ {
+		encoded, err := mc.Marshal(Multicodec(), &n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n2 ipld.Node
+		if err := mc.Unmarshal(Multicodec(), encoded, &n2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
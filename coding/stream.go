@@ -0,0 +1,196 @@
+package ipfsld
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	mc "github.com/jbenet/go-multicodec"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+var jsonHeader = JsonMulticodec().Header()
+
+// Framing tells how the objects of a stream are delimited.
+type Framing int
+
+const (
+	// Concatenated streams are made of multicodec prefixed objects, one
+	// after the other. Each object may or may not start with the
+	// /multicodec header of the muxing codec.
+	Concatenated Framing = iota
+
+	// Msgio streams are made of msgio frames: each multicodec prefixed
+	// object is preceded by its length, as a 4 byte big-endian integer.
+	Msgio
+)
+
+// DefaultMaxFrameSize is the default MaxFrameSize of a StreamReader, the
+// largest msgio frame accepted by the msgio readers.
+const DefaultMaxFrameSize = 8 * 1024 * 1024
+
+// ErrFrameTooLarge is the error of the StreamError returned for a Msgio frame
+// longer than the MaxFrameSize of the StreamReader.
+var ErrFrameTooLarge = errors.New("msgio frame too large")
+
+// StreamError is returned by StreamReader when an object cannot be decoded.
+type StreamError struct {
+	Offset int64 // byte offset of the start of the object
+	Err    error
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("object at byte %d: %s", e.Offset, e.Err)
+}
+
+// StreamReader decodes successive nodes from a stream, using any of the
+// codecs known to Multicodec().
+type StreamReader struct {
+	// MaxFrameSize is the length of the longest Msgio frame accepted, so
+	// that a corrupt length does not allocate more. It defaults to
+	// DefaultMaxFrameSize.
+	MaxFrameSize uint32
+
+	r       *bufio.Reader
+	framing Framing
+	offset  int64 // bytes consumed from r
+	last    int64 // offset of the last object
+	err     error // sticky error
+}
+
+// NewStreamReader returns a StreamReader reading from r.
+func NewStreamReader(r io.Reader, framing Framing) *StreamReader {
+	return &StreamReader{MaxFrameSize: DefaultMaxFrameSize, r: bufio.NewReader(r), framing: framing}
+}
+
+// Next decodes the next node of the stream. It returns io.EOF when the stream
+// ends cleanly between two objects, and a *StreamError if an object could not
+// be decoded. After an error, the stream cannot be read any further, except
+// for Msgio streams whose frame could be read entirely: the next call to Next
+// then decodes the following frame.
+func (s *StreamReader) Next() (ipld.Node, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	if _, err := s.r.Peek(1); err != nil {
+		s.err = err
+		return nil, err
+	}
+
+	s.last = s.offset
+	var n ipld.Node
+	if s.framing == Msgio {
+		frame, err := s.readFrame()
+		if err != nil {
+			return nil, s.fail(err)
+		}
+
+		// the frame was read entirely, so the stream is still usable.
+		if _, err := decodeStreamObject(bytes.NewReader(frame), &n); err != nil {
+			return nil, &StreamError{Offset: s.last, Err: unexpectedEOF(err)}
+		}
+		return n, nil
+	}
+
+	hdr, err := decodeStreamObject(streamByteReader{s}, &n)
+	if err != nil {
+		return nil, s.fail(err)
+	}
+
+	// the JSON encoder terminates values with a newline
+	if bytes.Equal(hdr, jsonHeader) {
+		if b, err := s.r.Peek(1); err == nil && b[0] == '\n' {
+			s.r.ReadByte()
+			s.offset++
+		}
+	}
+	return n, nil
+}
+
+// Offset returns the byte offset at which the last object returned by Next,
+// or the one that failed to decode, starts.
+func (s *StreamReader) Offset() int64 {
+	return s.last
+}
+
+// fail makes err the sticky error of the stream.
+func (s *StreamReader) fail(err error) error {
+	s.err = &StreamError{Offset: s.last, Err: unexpectedEOF(err)}
+	return s.err
+}
+
+func (s *StreamReader) readFrame() ([]byte, error) {
+	var lbuf [4]byte
+	if err := s.readFull(lbuf[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(lbuf[:])
+	if size > s.MaxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	frame := make([]byte, size)
+	if err := s.readFull(frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (s *StreamReader) readFull(b []byte) error {
+	n, err := io.ReadFull(s.r, b)
+	s.offset += int64(n)
+	return err
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF, for errors occurring
+// in the middle of an object.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// streamByteReader reads from a StreamReader one byte at a time, so that
+// decoders which buffer their input never read past the end of an object.
+type streamByteReader struct {
+	s *StreamReader
+}
+
+func (r streamByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	b, err := r.s.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	r.s.offset++
+	p[0] = b
+	return 1, nil
+}
+
+// decodeStreamObject decodes a multicodec prefixed object, which may or may
+// not be wrapped in the /multicodec header. It returns the header of the
+// codec used.
+func decodeStreamObject(r io.Reader, n *ipld.Node) ([]byte, error) {
+	hdr, err := mc.ReadHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(hdr, muxCodec.Header()) {
+		if hdr, err = mc.ReadHeader(r); err != nil {
+			return nil, err
+		}
+	}
+
+	r = mc.WrapHeaderReader(muxCodec.Header(), mc.WrapHeaderReader(hdr, r))
+	return hdr, muxCodec.Decoder(r).Decode(n)
+}
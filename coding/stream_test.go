@@ -0,0 +1,134 @@
+package ipfsld
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+func streamObjects(t *testing.T) [][]byte {
+	pbfile, err := ioutil.ReadFile("pb/testfile")
+	if err != nil {
+		t.Fatal("could not read pb/testfile. please run: make -C pb testfile")
+	}
+	return [][]byte{
+		codedFiles["json.testfile"],
+		codedFiles["cbor.testfile"],
+		pbfile,
+		codedFiles["json.testfile"],
+	}
+}
+
+func checkStreamNode(t *testing.T, i int, n ipld.Node) {
+	if _, ok := n["@attrs"]; ok {
+		return // the protobuf node
+	}
+	if len(n.Links()) != 1 {
+		t.Errorf("object %d: expected 1 link, got %#v", i, n)
+	}
+}
+
+func TestStreamConcatenated(t *testing.T) {
+	objects := streamObjects(t)
+
+	var stream []byte
+	var offsets []int64
+	for _, o := range objects {
+		offsets = append(offsets, int64(len(stream)))
+		stream = append(stream, o...)
+	}
+	garbageOffset := int64(len(stream))
+	stream = append(stream, []byte("\x05/foo\n{}")...)
+
+	r := NewStreamReader(bytes.NewReader(stream), Concatenated)
+	for i := range objects {
+		n, err := r.Next()
+		if err != nil {
+			t.Fatalf("object %d: %s", i, err)
+		}
+		if r.Offset() != offsets[i] {
+			t.Errorf("object %d: expected offset %d, got %d", i, offsets[i], r.Offset())
+		}
+		checkStreamNode(t, i, n)
+	}
+
+	_, err := r.Next()
+	serr, ok := err.(*StreamError)
+	if !ok {
+		t.Fatalf("expected a StreamError, got %v", err)
+	}
+	if serr.Offset != garbageOffset {
+		t.Errorf("expected error at offset %d, got %d", garbageOffset, serr.Offset)
+	}
+	if _, err2 := r.Next(); err2 != err {
+		t.Error("stream error is not sticky")
+	}
+}
+
+func TestStreamMsgio(t *testing.T) {
+	objects := streamObjects(t)
+	objects = append(objects[:2], append([][]byte{[]byte("\x05/foo\n{}")}, objects[2:]...)...)
+
+	var stream []byte
+	var offsets []int64
+	for _, o := range objects {
+		offsets = append(offsets, int64(len(stream)))
+		var l [4]byte
+		binary.BigEndian.PutUint32(l[:], uint32(len(o)))
+		stream = append(stream, l[:]...)
+		stream = append(stream, o...)
+	}
+
+	r := NewStreamReader(bytes.NewReader(stream), Msgio)
+	for i := range objects {
+		n, err := r.Next()
+		if r.Offset() != offsets[i] {
+			t.Errorf("object %d: expected offset %d, got %d", i, offsets[i], r.Offset())
+		}
+		if i == 2 {
+			// the invalid frame does not prevent reading the next ones
+			if serr, ok := err.(*StreamError); !ok || serr.Offset != offsets[i] {
+				t.Errorf("object %d: expected a StreamError, got %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("object %d: %s", i, err)
+		}
+		checkStreamNode(t, i, n)
+	}
+
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+
+	// truncated frame
+	r = NewStreamReader(bytes.NewReader(stream[:len(stream)-1]), Msgio)
+	var err error
+	for range objects {
+		_, err = r.Next()
+	}
+	if serr, ok := err.(*StreamError); !ok || serr.Err != io.ErrUnexpectedEOF {
+		t.Errorf("expected an unexpected EOF, got %v", err)
+	}
+
+	// frames longer than the max frame size are refused before being read
+	r = NewStreamReader(bytes.NewReader(append(append([]byte{}, stream[:offsets[1]]...), "\xff\xff\xff\xff"...)), Msgio)
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Next()
+	if serr, ok := err.(*StreamError); !ok || serr.Err != ErrFrameTooLarge || serr.Offset != offsets[1] {
+		t.Errorf("expected a too large frame at offset %d, got %v", offsets[1], err)
+	}
+
+	r = NewStreamReader(bytes.NewReader(stream), Msgio)
+	r.MaxFrameSize = uint32(len(objects[0]) - 1)
+	if _, err := r.Next(); err == nil || err.(*StreamError).Err != ErrFrameTooLarge {
+		t.Errorf("expected a too large frame, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package ipfsld
+
+import (
+	"fmt"
+	"io"
+
+	mc "github.com/jbenet/go-multicodec"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+// CodecMismatchError is returned by the decoders of StrictMulticodec when a
+// node would not be encoded back with the codec it was encoded with.
+type CodecMismatchError struct {
+	Header string      // header path of the codec the node was encoded with
+	Codec  interface{} // the @codec value of the node, nil if it has none
+}
+
+func (e *CodecMismatchError) Error() string {
+	if e.Codec == nil {
+		return fmt.Sprintf("node encoded with %s has no %s", e.Header, ipld.CodecKey)
+	}
+	return fmt.Sprintf("node encoded with %s has %s %#v", e.Header, ipld.CodecKey, e.Codec)
+}
+
+// StrictMulticodec is like Multicodec, but its decoders reject the nodes that
+// Multicodec would encode back with another codec than the one of their
+// header, and so with another hash: the nodes whose @codec disagrees with the
+// header, or which have no @codec while the header is not the one of the
+// default codec.
+//
+// The returned codec only knows about the codecs registered at the time it
+// is created.
+func StrictMulticodec() mc.Multicodec {
+	codecs := List()
+	strict := make([]mc.Multicodec, len(codecs))
+	for i, c := range codecs {
+		strict[i] = &strictCodec{c}
+	}
+	return newCodecMux(strict)
+}
+
+type strictCodec struct {
+	mc.Multicodec
+}
+
+type strictDecoder struct {
+	mc.Decoder
+	header string
+}
+
+func (c *strictCodec) Decoder(r io.Reader) mc.Decoder {
+	return &strictDecoder{c.Multicodec.Decoder(r), string(mc.HeaderPath(c.Header()))}
+}
+
+func (d *strictDecoder) Decode(v interface{}) error {
+	if err := d.Decoder.Decode(v); err != nil {
+		return err
+	}
+
+	vn, ok := v.(*ipld.Node)
+	if !ok {
+		return nil
+	}
+	if codec, err := codecKey(*vn); err != nil || codec != d.header {
+		return &CodecMismatchError{Header: d.header, Codec: (*vn)[ipld.CodecKey]}
+	}
+	return nil
+}
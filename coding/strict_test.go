@@ -0,0 +1,105 @@
+package ipfsld
+
+import (
+	"strings"
+	"testing"
+
+	ipld "github.com/ipfs/go-ipld"
+
+	mc "github.com/jbenet/go-multicodec"
+)
+
+func TestUnknownCodec(t *testing.T) {
+	for _, tc := range []struct {
+		codec   interface{}
+		message string
+	}{
+		{"/foo", `unknown codec "/foo"`},
+		{42, `invalid @codec 42: not a string`},
+	} {
+		n := ipld.Node{ipld.CodecKey: tc.codec, "foo": "bar"}
+		_, err := mc.Marshal(Multicodec(), &n)
+		cerr, ok := err.(*CodecError)
+		if !ok {
+			t.Errorf("%v: expected a *CodecError, got %v", tc.codec, err)
+			continue
+		}
+		if cerr.Codec != tc.codec {
+			t.Errorf("%v: error names codec %v", tc.codec, cerr.Codec)
+		}
+		if !strings.HasPrefix(err.Error(), tc.message) {
+			t.Errorf("%v: unexpected message: %s", tc.codec, err)
+		}
+		for _, name := range []string{"/cbor", "/json", "/mdagv1"} {
+			if !strings.Contains(err.Error(), name) {
+				t.Errorf("%v: error does not list codec %s: %s", tc.codec, name, err)
+			}
+		}
+	}
+}
+
+func TestStrictMulticodec(t *testing.T) {
+	for _, codec := range []string{"/cbor", "/json"} {
+		n := ipld.Node{ipld.CodecKey: codec, "foo": "bar"}
+		encoded, err := mc.Marshal(Multicodec(), &n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var n2 ipld.Node
+		if err := mc.Unmarshal(StrictMulticodec(), encoded, &n2); err != nil {
+			t.Errorf("%s: %s", codec, err)
+		}
+
+		// encode the node with the other codec, keeping its @codec
+		other := "/json"
+		if codec == other {
+			other = "/cbor"
+		}
+		encoded, err = mc.Marshal(LookupByName(other), &n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded = append(Multicodec().Header(), encoded...)
+
+		if err := mc.Unmarshal(Multicodec(), encoded, &n2); err != nil {
+			t.Errorf("%s: %s", codec, err)
+		}
+		err = mc.Unmarshal(StrictMulticodec(), encoded, &n2)
+		if merr, ok := err.(*CodecMismatchError); !ok || merr.Header != other || merr.Codec != codec {
+			t.Errorf("%s: expected a mismatch with %s, got %v", codec, other, err)
+		}
+	}
+
+	// nodes without @codec are accepted with the default codec only
+	n := ipld.Node{"foo": "bar"}
+	encoded, err := mc.Marshal(Multicodec(), &n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n2 ipld.Node
+	if err := mc.Unmarshal(StrictMulticodec(), encoded, &n2); err != nil {
+		t.Error(err)
+	}
+
+	encoded, err = mc.Marshal(JsonMulticodec(), &n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded = append(Multicodec().Header(), encoded...)
+	if err := mc.Unmarshal(Multicodec(), encoded, &n2); err != nil {
+		t.Error(err)
+	}
+	err = mc.Unmarshal(StrictMulticodec(), encoded, &n2)
+	if merr, ok := err.(*CodecMismatchError); !ok || merr.Header != "/json" || merr.Codec != nil {
+		t.Errorf("expected a mismatch with /json, got %v", err)
+	}
+
+	// and @codec must be a string
+	encoded = append(Multicodec().Header(), JsonMulticodec().Header()...)
+	encoded = append(encoded, `{"@codec":42,"foo":"bar"}`...)
+	err = mc.Unmarshal(StrictMulticodec(), encoded, &n2)
+	if merr, ok := err.(*CodecMismatchError); !ok || merr.Codec != int64(42) {
+		t.Errorf("expected a mismatch with @codec 42, got %v", err)
+	}
+}
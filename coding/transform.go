@@ -1,7 +1,15 @@
 package ipfsld
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
 
 	mc "github.com/jbenet/go-multicodec"
 	mcjson "github.com/jbenet/go-multicodec/json"
@@ -17,12 +25,55 @@ type transformDecoder struct {
 	mc.Decoder
 }
 
+// jsonCodec is the JSON multicodec, tweaked to follow our number model: it
+// decodes numbers as json.Number so that integers and floats can be told
+// apart, and always encodes floats with a decimal point or an exponent. It
+// also encodes byte strings with a reserved shape, see jsonValue.
+type jsonCodec struct {
+	mc.Multicodec
+	indent string // pretty printing indent, see PrettyJsonMulticodec
+}
+
+type jsonEncoder struct {
+	w io.Writer
+	c *jsonCodec
+}
+
+type jsonDecoder struct {
+	r io.Reader
+	c *jsonCodec
+	l *Limits // checked before decoding, if not nil
+}
+
 func JsonMulticodec() mc.Multicodec {
-	return &transformCodec{mcjson.Multicodec(false)}
+	return &transformCodec{&jsonCodec{mcjson.Multicodec(false), ""}}
 }
 
+// cborCodec is the CBOR multicodec, refusing to encode the integers of our
+// number model that CBOR cannot carry natively.
+type cborCodec struct {
+	mc.Multicodec
+}
+
+type cborEncoder struct {
+	mc.Encoder
+}
+
+// cborDecoder reads a single CBOR item, checking it against the limits as it
+// is read, before handing it to the decoder of the CBOR multicodec.
+type cborDecoder struct {
+	r io.Reader
+	c *cborCodec
+	l *Limits
+}
+
+var (
+	errCborBigInt        = errors.New("cannot encode integers larger than 64 bits in CBOR")
+	errJsonReservedBytes = errors.New("cannot encode a map with the reserved @bytes shape in JSON")
+)
+
 func CborMulticodec() mc.Multicodec {
-	return &transformCodec{mccbor.Multicodec()}
+	return &transformCodec{&cborCodec{mccbor.Multicodec()}}
 }
 
 func (c *transformCodec) Decoder(r io.Reader) mc.Decoder {
@@ -30,67 +81,384 @@ func (c *transformCodec) Decoder(r io.Reader) mc.Decoder {
 }
 
 func (c *transformDecoder) Decode(v interface{}) error {
-	err := c.Decoder.Decode(v)
-	if err == nil {
-		convert(v)
+	if err := c.Decoder.Decode(v); err != nil {
+		return err
 	}
+	_, err := convert(v)
 	return err
 }
 
-func convert(val interface{}) interface{} {
+func (c *cborCodec) Encoder(w io.Writer) mc.Encoder {
+	return &cborEncoder{c.Multicodec.Encoder(w)}
+}
+
+func (c *cborCodec) Decoder(r io.Reader) mc.Decoder {
+	return c.limitedDecoder(r, Limits{})
+}
+
+func (c *cborCodec) limitedDecoder(r io.Reader, l Limits) mc.Decoder {
+	return &cborDecoder{r, c, decodeLimits(l)}
+}
+
+func (d *cborDecoder) Decode(v interface{}) error {
+	hdr := d.c.Header()
+	if err := mc.ConsumeHeader(d.r, hdr); err != nil {
+		return err
+	}
+	s := &cborScanner{r: d.r, l: d.l}
+	if err := s.item(1); err != nil {
+		return err
+	}
+	r := io.MultiReader(bytes.NewReader(hdr), bytes.NewReader(s.buf))
+	return d.c.Multicodec.Decoder(r).Decode(v)
+}
+
+func (c *cborEncoder) Encode(v interface{}) error {
+	if hasBigInt(v) {
+		return errCborBigInt
+	}
+	return c.Encoder.Encode(v)
+}
+
+// hasBigInt returns whether v contains a *big.Int.
+func hasBigInt(val interface{}) bool {
+	switch val.(type) {
+	case *big.Int:
+		return true
+	case *ipld.Node:
+		return hasBigInt(*val.(*ipld.Node))
+	case ipld.Node:
+		for _, v := range val.(ipld.Node) {
+			if hasBigInt(v) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, v := range val.(map[string]interface{}) {
+			if hasBigInt(v) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, v := range val.([]interface{}) {
+			if hasBigInt(v) {
+				return true
+			}
+		}
+	case []ipld.Node:
+		for _, v := range val.([]ipld.Node) {
+			if hasBigInt(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *jsonCodec) Encoder(w io.Writer) mc.Encoder {
+	return &jsonEncoder{w, c}
+}
+
+func (c *jsonCodec) Decoder(r io.Reader) mc.Decoder {
+	return &jsonDecoder{r, c, nil}
+}
+
+func (c *jsonCodec) limitedDecoder(r io.Reader, l Limits) mc.Decoder {
+	return &jsonDecoder{r, c, &l}
+}
+
+func (c *jsonEncoder) Encode(v interface{}) error {
+	if err := mc.WriteHeader(c.w, c.c.Header()); err != nil {
+		return err
+	}
+	jv, err := jsonValue(v)
+	if err != nil {
+		return err
+	}
+	if c.c.indent != "" {
+		return writePrettyJson(c.w, jv, c.c.indent)
+	}
+	return json.NewEncoder(c.w).Encode(jv)
+}
+
+func (c *jsonDecoder) Decode(v interface{}) error {
+	if err := mc.ConsumeHeader(c.r, c.c.Header()); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(c.r)
+	dec.UseNumber()
+	if c.l != nil {
+		// check the tokens before building any value
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		if err := checkJsonLimits(raw, c.l); err != nil {
+			return err
+		}
+		dec = json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+	}
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	decodeJsonBytes(v)
+	return nil
+}
+
+// jsonFloat is a float64 that is always encoded as a JSON float, even when
+// it has an integral value, so that it is decoded back as a float.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(float64(f))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.ContainsAny(b, ".eE") {
+		b = append(b, ".0"...)
+	}
+	return b, nil
+}
+
+// jsonValue returns a copy of v suitable for the JSON encoder: floats are
+// replaced by jsonFloat, and byte strings by the reserved shape:
+//
+//   { "@bytes": "<base64>" }
+//
+// It is an error for v to contain that shape already, as it would decode back
+// as a byte string. Links need no special care, as they are already made of
+// strings: { "mlink": "<multihash>" }
+func jsonValue(val interface{}) (interface{}, error) {
+	switch val.(type) {
+	case *ipld.Node:
+		return jsonValue(*val.(*ipld.Node))
+	case ipld.Node:
+		return jsonValue(map[string]interface{}(val.(ipld.Node)))
+	case map[string]interface{}:
+		vmi := val.(map[string]interface{})
+		if _, ok := jsonBytes(vmi); ok {
+			return nil, errJsonReservedBytes
+		}
+		res := make(map[string]interface{}, len(vmi))
+		for k, v := range vmi {
+			jv, err := jsonValue(v)
+			if err != nil {
+				return nil, err
+			}
+			res[k] = jv
+		}
+		return res, nil
+	case []interface{}:
+		slice := val.([]interface{})
+		res := make([]interface{}, len(slice))
+		for k, v := range slice {
+			jv, err := jsonValue(v)
+			if err != nil {
+				return nil, err
+			}
+			res[k] = jv
+		}
+		return res, nil
+	case []ipld.Node:
+		slice := val.([]ipld.Node)
+		res := make([]interface{}, len(slice))
+		for k, v := range slice {
+			jv, err := jsonValue(v)
+			if err != nil {
+				return nil, err
+			}
+			res[k] = jv
+		}
+		return res, nil
+	case []byte:
+		return map[string]interface{}{
+			ipld.BytesKey: base64.StdEncoding.EncodeToString(val.([]byte)),
+		}, nil
+	case float64:
+		return jsonFloat(val.(float64)), nil
+	case float32:
+		return jsonFloat(val.(float32)), nil
+	default:
+		return val, nil
+	}
+}
+
+// jsonBytes returns the byte string represented by m, if it has the reserved
+// shape used for byte strings.
+func jsonBytes(m map[string]interface{}) ([]byte, bool) {
+	if len(m) != 1 {
+		return nil, false
+	}
+	s, ok := m[ipld.BytesKey].(string)
+	if !ok {
+		return nil, false
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// decodeJsonBytes replaces, in the values produced by the JSON decoder, the
+// maps with the reserved shape by the byte strings they represent.
+func decodeJsonBytes(val interface{}) interface{} {
+	switch val.(type) {
+	case *ipld.Node:
+		decodeJsonBytes(map[string]interface{}(*val.(*ipld.Node)))
+	case map[string]interface{}:
+		vmi := val.(map[string]interface{})
+		if b, ok := jsonBytes(vmi); ok {
+			return b
+		}
+		for k, v := range vmi {
+			vmi[k] = decodeJsonBytes(v)
+		}
+	case []interface{}:
+		slice := val.([]interface{})
+		for k, v := range slice {
+			slice[k] = decodeJsonBytes(v)
+		}
+	}
+	return val
+}
+
+// convert turns the values produced by the underlying decoders into the ones
+// exposed by this package. Maps become ipld.Node, and numbers follow the same
+// model whatever the codec:
+//
+//   - integers are int64, or uint64 if they are positive and do not fit an
+//     int64, or *big.Int if they do not even fit an uint64 (only JSON can
+//     carry those)
+//   - floats are float64, and are only produced by numbers encoded as floats
+//
+// It fails on values nested deeper than maxDepth, rather than exhausting the
+// stack on hostile input.
+func convert(val interface{}) (interface{}, error) {
+	return convertAt(val, 1)
+}
+
+// convertAt converts val, found at the given depth.
+func convertAt(val interface{}, depth int) (interface{}, error) {
+	switch val.(type) {
+	case *map[string]interface{}, map[string]interface{},
+		*map[interface{}]interface{}, map[interface{}]interface{},
+		*[]interface{}, []interface{}, *ipld.Node, ipld.Node:
+		if depth > maxDepth {
+			return nil, &LimitError{DepthLimit, maxDepth}
+		}
+	}
+
 	switch val.(type) {
 	case *map[string]interface{}:
 		vmi := val.(*map[string]interface{})
 		n := ipld.Node{}
 		for k, v := range *vmi {
-			n[k] = convert(v)
-			(*vmi)[k] = convert(v)
+			cv, err := convertAt(v, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			n[k] = cv
+			(*vmi)[k] = cv
 		}
-		return &n
+		return &n, nil
 	case map[string]interface{}:
 		vmi := val.(map[string]interface{})
 		n := ipld.Node{}
 		for k, v := range vmi {
-			n[k] = convert(v)
-			vmi[k] = convert(v)
+			cv, err := convertAt(v, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			n[k] = cv
+			vmi[k] = cv
 		}
-		return n
+		return n, nil
 	case *map[interface{}]interface{}:
 		vmi := val.(*map[interface{}]interface{})
 		n := ipld.Node{}
 		for k, v := range *vmi {
 			if k2, ok := k.(string); ok {
-				n[k2] = convert(v)
-				(*vmi)[k2] = convert(v)
+				cv, err := convertAt(v, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				n[k2] = cv
+				(*vmi)[k2] = cv
 			}
 		}
-		return &n
+		return &n, nil
 	case map[interface{}]interface{}:
 		vmi := val.(map[interface{}]interface{})
 		n := ipld.Node{}
 		for k, v := range vmi {
 			if k2, ok := k.(string); ok {
-				n[k2] = convert(v)
-				vmi[k2] = convert(v)
+				cv, err := convertAt(v, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				n[k2] = cv
+				vmi[k2] = cv
 			}
 		}
-		return n
+		return n, nil
 	case *[]interface{}:
-		convert(*val.(*[]interface{}))
+		if _, err := convertAt(*val.(*[]interface{}), depth); err != nil {
+			return nil, err
+		}
 	case []interface{}:
 		slice := val.([]interface{})
 		for k, v := range slice {
-			slice[k] = convert(v)
+			cv, err := convertAt(v, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			slice[k] = cv
 		}
 	case *ipld.Node:
-		convert(*val.(*ipld.Node))
+		if _, err := convertAt(*val.(*ipld.Node), depth); err != nil {
+			return nil, err
+		}
 	case ipld.Node:
 		n := val.(ipld.Node)
 		for k, v := range n {
-			n[k] = convert(v)
+			cv, err := convertAt(v, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			n[k] = cv
+		}
+	case json.Number:
+		return convertNumber(val.(json.Number)), nil
+	case uint64:
+		if u := val.(uint64); u <= math.MaxInt64 {
+			return int64(u), nil
 		}
+	case float32:
+		return float64(val.(float32)), nil
 	default:
 	}
-	return val
+	return val, nil
+}
+
+// convertNumber converts a JSON number according to our number model.
+func convertNumber(n json.Number) interface{} {
+	s := string(n)
+	if strings.ContainsAny(s, ".eE") {
+		f, _ := n.Float64()
+		return f
+	}
+
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return u
+	}
+	if b, ok := new(big.Int).SetString(s, 10); ok {
+		return b
+	}
+	return s
 }
 
@@ -0,0 +1,323 @@
+package ipfsld
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	mc "github.com/jbenet/go-multicodec"
+	yaml "gopkg.in/yaml.v3"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+var yamlHeader = mc.Header([]byte("/yaml"))
+
+// Tags of the YAML scalars representing links and byte strings.
+const (
+	yamlLinkTag   = "!" + ipld.LinkKey
+	yamlBinaryTag = "!!binary"
+)
+
+var (
+	errYamlRoot      = errors.New("YAML document must be a mapping")
+	errYamlDocuments = errors.New("YAML object must be a single document")
+	errYamlAnchor    = errors.New("YAML anchors and aliases are not supported")
+	errYamlKey       = errors.New("YAML mapping keys must be strings")
+	errYamlString    = errors.New("cannot encode strings which are not valid UTF-8 in YAML")
+)
+
+// YamlMulticodec returns the YAML codec, meant for nodes written by hand. YAML
+// mappings are nodes, and follow the same number model as the other codecs.
+// Links and byte strings can be written with tags:
+//
+//	schema: !mlink QmZku7P7KeeHAnwMr6c4HveYfMzmtVinNXzibkiNbfDbPo
+//	data: !!binary AAFiaW5hcnn/
+//
+// Links with other properties are written as mappings with a "mlink" key.
+//
+// The YAML features which would not survive a round trip are rejected when
+// decoding: anchors and aliases, keys which are not strings, and objects made
+// of several documents. As YAML has no end marker, the decoder reads its input
+// until the end.
+//
+// The encoding is canonical, with sorted keys, but comments and formatting are
+// lost: hashes should rather be taken of another encoding, such as CBOR.
+func YamlMulticodec() mc.Multicodec {
+	return &yamlCodec{}
+}
+
+type yamlCodec struct{}
+
+type yamlEncoder struct {
+	w io.Writer
+}
+
+type yamlDecoder struct {
+	r io.Reader
+}
+
+func (c *yamlCodec) Header() []byte {
+	return yamlHeader
+}
+
+func (c *yamlCodec) Encoder(w io.Writer) mc.Encoder {
+	return &yamlEncoder{w}
+}
+
+func (c *yamlCodec) Decoder(r io.Reader) mc.Decoder {
+	return &yamlDecoder{r}
+}
+
+func (e *yamlEncoder) Encode(v interface{}) error {
+	yn, err := yamlNode(v)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(yn); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+
+	if err := mc.WriteHeader(e.w, yamlHeader); err != nil {
+		return err
+	}
+	_, err = e.w.Write(buf.Bytes())
+	return err
+}
+
+func (d *yamlDecoder) Decode(v interface{}) error {
+	vn, ok := v.(*ipld.Node)
+	if !ok {
+		return errors.New("must decode to *ipld.Node")
+	}
+
+	if err := mc.ConsumeHeader(d.r, yamlHeader); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(d.r)
+	if err != nil {
+		return err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var doc yaml.Node
+	if err := dec.Decode(&doc); err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	}
+	var next yaml.Node
+	if err := dec.Decode(&next); err != io.EOF {
+		return errYamlDocuments
+	}
+
+	if len(doc.Content) == 0 {
+		return errYamlRoot
+	}
+	val, err := yamlValue(doc.Content[0])
+	if err != nil {
+		return err
+	}
+	n, ok := val.(ipld.Node)
+	if !ok {
+		return errYamlRoot
+	}
+	*vn = n
+	return nil
+}
+
+// yamlValue returns the value represented by a YAML node.
+func yamlValue(yn *yaml.Node) (interface{}, error) {
+	if yn.Anchor != "" || yn.Kind == yaml.AliasNode {
+		return nil, errYamlAnchor
+	}
+
+	switch yn.Kind {
+	case yaml.MappingNode:
+		n := ipld.Node{}
+		for i := 0; i+1 < len(yn.Content); i += 2 {
+			k, v := yn.Content[i], yn.Content[i+1]
+			if k.Kind != yaml.ScalarNode || k.ShortTag() != "!!str" || k.Anchor != "" {
+				return nil, errYamlKey
+			}
+			if _, ok := n[k.Value]; ok {
+				return nil, fmt.Errorf("line %d: duplicate key %q", k.Line, k.Value)
+			}
+
+			val, err := yamlValue(v)
+			if err != nil {
+				return nil, err
+			}
+			n[k.Value] = val
+		}
+		return n, nil
+
+	case yaml.SequenceNode:
+		res := make([]interface{}, len(yn.Content))
+		for i, c := range yn.Content {
+			val, err := yamlValue(c)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = val
+		}
+		return res, nil
+
+	case yaml.ScalarNode:
+		return yamlScalar(yn)
+	}
+	return nil, fmt.Errorf("line %d: unsupported YAML node", yn.Line)
+}
+
+func yamlScalar(yn *yaml.Node) (interface{}, error) {
+	switch yn.ShortTag() {
+	case "!!str", "!!timestamp":
+		return yn.Value, nil
+	case "!!null":
+		return nil, nil
+	case "!!bool":
+		var b bool
+		err := yn.Decode(&b)
+		return b, err
+	case "!!int":
+		var i interface{}
+		if err := yn.Decode(&i); err == nil {
+			return convert(yamlInt(i))
+		}
+		if b, ok := new(big.Int).SetString(yn.Value, 0); ok {
+			return b, nil
+		}
+	case "!!float":
+		var f float64
+		err := yn.Decode(&f)
+		return f, err
+	case yamlBinaryTag:
+		return base64.StdEncoding.DecodeString(yn.Value)
+	case yamlLinkTag:
+		return ipld.Node{ipld.LinkKey: yn.Value}, nil
+	}
+	return nil, fmt.Errorf("line %d: unsupported YAML value %s %q", yn.Line, yn.ShortTag(), yn.Value)
+}
+
+// yamlInt returns the integers decoded by the YAML package as int64 or
+// uint64.
+func yamlInt(i interface{}) interface{} {
+	switch ii := i.(type) {
+	case int:
+		return int64(ii)
+	case uint:
+		return uint64(ii)
+	}
+	return i
+}
+
+// yamlNode returns the YAML node representing v.
+func yamlNode(val interface{}) (*yaml.Node, error) {
+	switch v := val.(type) {
+	case *ipld.Node:
+		return yamlNode(*v)
+	case ipld.Node:
+		return yamlNode(map[string]interface{}(v))
+	case map[string]interface{}:
+		if l, ok := v[ipld.LinkKey].(string); ok && len(v) == 1 {
+			return yamlScalarNode(yamlLinkTag, l), nil
+		}
+
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		yn := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, k := range keys {
+			if !utf8.ValidString(k) {
+				return nil, errYamlString
+			}
+			c, err := yamlNode(v[k])
+			if err != nil {
+				return nil, err
+			}
+			yn.Content = append(yn.Content, yamlScalarNode("!!str", k), c)
+		}
+		return yn, nil
+	case []ipld.Node:
+		slice := make([]interface{}, len(v))
+		for i, n := range v {
+			slice[i] = n
+		}
+		return yamlNode(slice)
+	case []interface{}:
+		yn := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, c := range v {
+			cn, err := yamlNode(c)
+			if err != nil {
+				return nil, err
+			}
+			yn.Content = append(yn.Content, cn)
+		}
+		return yn, nil
+	case []byte:
+		return yamlScalarNode(yamlBinaryTag, base64.StdEncoding.EncodeToString(v)), nil
+	case string:
+		if !utf8.ValidString(v) {
+			return nil, errYamlString
+		}
+		return yamlScalarNode("!!str", v), nil
+	case nil:
+		return yamlScalarNode("!!null", "null"), nil
+	case bool:
+		return yamlScalarNode("!!bool", strconv.FormatBool(v)), nil
+	case int:
+		return yamlScalarNode("!!int", strconv.FormatInt(int64(v), 10)), nil
+	case int64:
+		return yamlScalarNode("!!int", strconv.FormatInt(v, 10)), nil
+	case uint64:
+		return yamlScalarNode("!!int", strconv.FormatUint(v, 10)), nil
+	case *big.Int:
+		return yamlScalarNode("!!int", v.String()), nil
+	case float32:
+		return yamlNode(float64(v))
+	case float64:
+		return yamlScalarNode("!!float", yamlFloat(v)), nil
+	}
+	return nil, fmt.Errorf("cannot encode %T in YAML", val)
+}
+
+func yamlScalarNode(tag, value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+}
+
+// yamlFloat formats f so that it is decoded back as a float.
+func yamlFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return ".inf"
+	case math.IsInf(f, -1):
+		return "-.inf"
+	case math.IsNaN(f):
+		return ".nan"
+	}
+
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
@@ -0,0 +1,100 @@
+package ipfsld
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	ipld "github.com/ipfs/go-ipld"
+
+	mc "github.com/jbenet/go-multicodec"
+)
+
+func TestYaml(t *testing.T) {
+	doc := `# a hand-written context
+"@context":
+  mlink: merkle-link
+schema: !mlink QmZku7P7KeeHAnwMr6c4HveYfMzmtVinNXzibkiNbfDbPo
+parent:
+  mlink: QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39V
+  size: 12
+data: !!binary AAFiaW5hcnn/
+numbers: [1, -2, 2.5, 0x10, 1.0e+3, 18446744073709551615]
+flags: {on: true, off: false, none: ~}
+"1": quoted key
+text: |
+  multi
+  line
+`
+	expected := ipld.Node{
+		"@context": ipld.Node{"mlink": "merkle-link"},
+		"schema":   ipld.Node{ipld.LinkKey: "QmZku7P7KeeHAnwMr6c4HveYfMzmtVinNXzibkiNbfDbPo"},
+		"parent": ipld.Node{
+			ipld.LinkKey: "QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39V",
+			"size":       int64(12),
+		},
+		"data":    []byte("\x00\x01binary\xff"),
+		"numbers": []interface{}{int64(1), int64(-2), 2.5, int64(16), 1000.0, uint64(18446744073709551615)},
+		"flags":   ipld.Node{"on": true, "off": false, "none": nil},
+		"1":       "quoted key",
+		"text":    "multi\nline\n",
+	}
+
+	var n ipld.Node
+	if err := mc.Unmarshal(Multicodec(), append(append(Multicodec().Header(), yamlHeader...), doc...), &n); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(n, expected) {
+		t.Logf("Expected: %#v", expected)
+		t.Logf("Actual:   %#v", n)
+		t.Fatal("unexpected YAML node")
+	}
+
+	// a YAML node hashes as the same node written in JSON
+	json := []byte(`{"@context":{"mlink":"merkle-link"},` +
+		`"schema":{"mlink":"QmZku7P7KeeHAnwMr6c4HveYfMzmtVinNXzibkiNbfDbPo"},` +
+		`"parent":{"mlink":"QmXg9Pp2ytZ14xgmQjYEiHjVjMFXzCVVEcRTWJBmLgR39V","size":12},` +
+		`"data":{"@bytes":"AAFiaW5hcnn/"},` +
+		`"numbers":[1,-2,2.5,16,1000.0,18446744073709551615],` +
+		`"flags":{"on":true,"off":false,"none":null},` +
+		`"1":"quoted key","text":"multi\nline\n"}`)
+	var nj ipld.Node
+	if err := mc.Unmarshal(JsonMulticodec(), append(JsonMulticodec().Header(), json...), &nj); err != nil {
+		t.Fatal(err)
+	}
+	cbor, err := mc.Marshal(CborMulticodec(), &n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cbor2, err := mc.Marshal(CborMulticodec(), &nj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cbor, cbor2) {
+		t.Error("YAML and JSON nodes have different CBOR encodings")
+	}
+}
+
+func TestYamlRejected(t *testing.T) {
+	for _, doc := range []string{
+		"",
+		"- not a mapping\n",
+		"a: &x 1\nb: *x\n",
+		"a: &x 1\n",
+		"1: integer key\n",
+		"? [a, b]\n: sequence key\n",
+		"a: 1\n---\nb: 2\n",
+		"a: 1\na: 2\n",
+		"a: !custom tag\n",
+	} {
+		var n ipld.Node
+		if err := mc.Unmarshal(YamlMulticodec(), append(yamlHeader, doc...), &n); err == nil {
+			t.Errorf("decoded %q", doc)
+		}
+	}
+
+	n := ipld.Node{"a": "\xff"}
+	if _, err := mc.Marshal(YamlMulticodec(), &n); err != errYamlString {
+		t.Errorf("expected errYamlString, got %v", err)
+	}
+}
@@ -16,6 +16,8 @@ const (
 
 	CodecKey = "@codec" // used to determine which multicodec to use
 	LinkKey  = "mlink"  // key for merkle-links
+	BytesKey = "@bytes" // key for byte strings, in codecs lacking them (JSON)
+	RawKey   = "@raw"   // the content of raw leaf nodes
 )
 
 // Node is an IPLD node. effectively, it is equivalent to a JSON-LD object.
@@ -47,6 +49,13 @@ func (d Node) Context() interface{} {
 	return d[CtxKey]
 }
 
+// RawData is a convenience method to retrieve the content of a raw leaf node,
+// as decoded by the /raw codec. ok is false if d is not a raw node.
+func (d Node) RawData() (data []byte, ok bool) {
+	data, ok = d[RawKey].([]byte)
+	return
+}
+
 // Links returns all the merkle-links in the document. When the document
 // is parsed, all the links are identified and references are cached, so
 // getting the links only walks the document _once_. Note though that the
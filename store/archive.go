@@ -0,0 +1,196 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	mc "github.com/jbenet/go-multicodec"
+	mh "github.com/jbenet/go-multihash"
+)
+
+// ArchiveHeader is the multicodec header starting every archive.
+var ArchiveHeader = mc.Header([]byte("/ipld/archive/v1"))
+
+// maxArchiveBlockSize bounds the size of the blocks read by Import, so that a
+// corrupted length prefix cannot make it allocate arbitrary amounts of memory.
+const maxArchiveBlockSize = 1 << 24
+
+var (
+	errArchiveMultihash = errors.New("invalid multihash in archive")
+	errArchiveBlockSize = errors.New("archive block too large")
+)
+
+// Export writes to w an archive of all the blocks reachable from the given
+// roots. The archive is made of:
+//
+//   - the ArchiveHeader
+//   - the number of roots, as an unsigned varint
+//   - each root multihash, prefixed by its length as an unsigned varint
+//   - a record for each block, in the order the DAG is traversed (depth
+//     first, links sorted by path), made of the block multihash and the
+//     encoded block, each prefixed by its length as an unsigned varint
+//
+// All the blocks reachable from roots must be in the store.
+func Export(s Store, roots []mh.Multihash, w io.Writer) error {
+	if err := mc.WriteHeader(w, ArchiveHeader); err != nil {
+		return err
+	}
+
+	if err := writeUvarint(w, uint64(len(roots))); err != nil {
+		return err
+	}
+	for _, root := range roots {
+		if err := writeBytes(w, root); err != nil {
+			return err
+		}
+	}
+
+	return walkDAG(s, roots, func(key mh.Multihash, block []byte, err error) error {
+		if err != nil {
+			return fmt.Errorf("block %s: %s", key.B58String(), err)
+		}
+
+		if err := writeBytes(w, key); err != nil {
+			return err
+		}
+		return writeBytes(w, block)
+	})
+}
+
+// Import reads an archive written by Export, and stores its blocks in s. The
+// whole archive is read and checked before any block is stored: the hash of
+// every block must match its multihash, and the roots must all be in the
+// archive. Import returns the roots of the archive. If it fails, nothing was
+// stored, unless s itself failed to store a block.
+func Import(r io.Reader, s Store) ([]mh.Multihash, error) {
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	if err := mc.ConsumeHeader(br, ArchiveHeader); err != nil {
+		return nil, err
+	}
+
+	nroots, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+
+	roots := make([]mh.Multihash, 0)
+	for i := uint64(0); i < nroots; i++ {
+		root, err := readMultihash(br)
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+
+	var keys []mh.Multihash
+	blocks := map[string][]byte{}
+	for {
+		key, err := readMultihash(br)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		block, err := readBytes(br, maxArchiveBlockSize)
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+
+		dm, err := mh.Decode(key)
+		if err != nil {
+			return nil, err
+		}
+		h, err := mh.Sum(block, dm.Code, dm.Length)
+		if err != nil {
+			return nil, err
+		}
+		if string(h) != string(key) {
+			return nil, fmt.Errorf("block %s: hash mismatch", key.B58String())
+		}
+
+		if _, ok := blocks[string(key)]; !ok {
+			keys = append(keys, key)
+		}
+		blocks[string(key)] = block
+	}
+
+	for _, root := range roots {
+		if _, ok := blocks[string(root)]; !ok {
+			return nil, fmt.Errorf("root %s: %s", root.B58String(), ErrNotFound)
+		}
+	}
+
+	for _, key := range keys {
+		if err := s.Put(key, blocks[string(key)]); err != nil {
+			return nil, err
+		}
+	}
+	return roots, nil
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func writeUvarint(w io.Writer, v uint64) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, v)
+	_, err := w.Write(buf[:n])
+	return err
+}
+
+func writeBytes(w io.Writer, b []byte) error {
+	if err := writeUvarint(w, uint64(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// readBytes reads a length prefixed byte string. It returns io.EOF only if
+// the stream ends before the length prefix.
+func readBytes(r byteReader, max uint64) ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if l > max {
+		return nil, errArchiveBlockSize
+	}
+
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func readMultihash(r byteReader) (mh.Multihash, error) {
+	b, err := readBytes(r, 129)
+	if err == errArchiveBlockSize {
+		return nil, errArchiveMultihash
+	} else if err != nil {
+		return nil, err
+	}
+
+	h, err := mh.Cast(b)
+	if err != nil {
+		return nil, errArchiveMultihash
+	}
+	return h, nil
+}
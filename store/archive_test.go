@@ -0,0 +1,99 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+
+	mh "github.com/jbenet/go-multihash"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+func TestArchive(t *testing.T) {
+	s := NewMapStore()
+	root, mid, leaf, other := makeDAG(t, s)
+	mustPut(t, s, ipld.Node{"value": "garbage"})
+
+	var buf bytes.Buffer
+	if err := Export(s, []mh.Multihash{root}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	archive := buf.Bytes()
+
+	s2 := NewMapStore()
+	roots, err := Import(bytes.NewReader(archive), s2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 1 || string(roots[0]) != string(root) {
+		t.Errorf("roots not imported: %v", roots)
+	}
+
+	keys, _ := s2.Keys()
+	if len(keys) != 4 {
+		t.Errorf("expected 4 blocks to be imported, got %d", len(keys))
+	}
+	for _, k := range []mh.Multihash{root, mid, leaf, other} {
+		b1, _ := s.Get(k)
+		b2, err := s2.Get(k)
+		if err != nil || !bytes.Equal(b1, b2) {
+			t.Errorf("block %s not imported", k.B58String())
+		}
+	}
+
+	// blocks are exported in traversal order, starting with the root
+	var buf2 bytes.Buffer
+	if err := Export(s2, roots, &buf2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(archive, buf2.Bytes()) {
+		t.Error("exporting the same DAG twice produced different archives")
+	}
+	var prefix bytes.Buffer
+	rootBlock, _ := s.Get(root)
+	prefix.Write(ArchiveHeader)
+	writeUvarint(&prefix, 1)
+	writeBytes(&prefix, root)
+	writeBytes(&prefix, root)
+	writeBytes(&prefix, rootBlock)
+	if !bytes.HasPrefix(archive, prefix.Bytes()) {
+		t.Error("root block is not the first record")
+	}
+}
+
+func TestArchiveCorrupted(t *testing.T) {
+	s := NewMapStore()
+	root, _, leaf, _ := makeDAG(t, s)
+
+	var buf bytes.Buffer
+	if err := Export(s, []mh.Multihash{root}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	archive := buf.Bytes()
+
+	// flip a byte of the leaf block
+	leafBlock, _ := s.Get(leaf)
+	i := bytes.Index(archive, leafBlock)
+	corrupted := append([]byte(nil), archive...)
+	corrupted[i+len(leafBlock)-1] ^= 0xff
+	s2 := NewMapStore()
+	if _, err := Import(bytes.NewReader(corrupted), s2); err == nil {
+		t.Error("corrupted block was imported")
+	}
+	if keys, _ := s2.Keys(); len(keys) != 0 {
+		t.Errorf("failed import stored %d blocks", len(keys))
+	}
+
+	if _, err := Import(bytes.NewReader(archive[:len(archive)-1]), s2); err == nil {
+		t.Error("truncated archive was imported")
+	}
+	if keys, _ := s2.Keys(); len(keys) != 0 {
+		t.Errorf("failed import stored %d blocks", len(keys))
+	}
+
+	// a missing block makes the export fail
+	s.Delete(leaf)
+	if err := Export(s, []mh.Multihash{root}, &buf); err == nil {
+		t.Error("incomplete DAG was exported")
+	}
+}
@@ -0,0 +1,171 @@
+package store
+
+import (
+	"sort"
+
+	mh "github.com/jbenet/go-multihash"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+// GCOptions configures a GC run. The zero value removes the unreachable
+// blocks without reporting progress.
+type GCOptions struct {
+	// DryRun reports the unreachable blocks without removing them.
+	DryRun bool
+
+	// Marked, if not nil, is called for every reachable block, as it is
+	// found during the mark phase.
+	Marked func(key mh.Multihash)
+
+	// Swept, if not nil, is called for every unreachable block during the
+	// sweep phase, after it was removed (or would have been, in a dry run).
+	Swept func(key mh.Multihash)
+}
+
+// GC removes from s all the blocks which cannot be reached from the given
+// roots by following merkle-links, as returned by Node.Links(). Blocks of any
+// codec understood by coding.Multicodec() are followed.
+//
+// GC returns the keys of the unreachable blocks. Links pointing to blocks
+// missing from the store are ignored, but all the roots must be present, and
+// any reachable block that cannot be decoded aborts the collection before
+// anything is removed.
+//
+// The links of git objects are git object ids, which are not store keys: the
+// git objects they point to are not reachable, unless they are roots.
+//
+// To keep pinned blocks, see Pinner.GC.
+func GC(s Store, roots []mh.Multihash, opts *GCOptions) ([]mh.Multihash, error) {
+	return collect(s, roots, nil, opts)
+}
+
+// collect is used to implement GC. The direct blocks are kept, but their
+// links are not followed.
+func collect(s Store, roots, direct []mh.Multihash, opts *GCOptions) ([]mh.Multihash, error) {
+	if opts == nil {
+		opts = &GCOptions{}
+	}
+
+	marked, err := mark(s, roots, direct, opts.Marked)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := s.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	var swept []mh.Multihash
+	for _, k := range keys {
+		if marked[string(k)] {
+			continue
+		}
+
+		if !opts.DryRun {
+			if err := s.Delete(k); err != nil {
+				return swept, err
+			}
+		}
+		swept = append(swept, k)
+		if opts.Swept != nil {
+			opts.Swept(k)
+		}
+	}
+	return swept, nil
+}
+
+// mark returns the set of keys reachable from roots, plus the direct keys.
+func mark(s Store, roots, direct []mh.Multihash, markedFn func(key mh.Multihash)) (map[string]bool, error) {
+	marked := map[string]bool{}
+
+	for _, root := range roots {
+		if ok, err := s.Has(root); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, ErrNotFound
+		}
+	}
+
+	for _, k := range direct {
+		if ok, err := s.Has(k); err != nil {
+			return nil, err
+		} else if ok && !marked[string(k)] {
+			marked[string(k)] = true
+			if markedFn != nil {
+				markedFn(k)
+			}
+		}
+	}
+
+	err := walkDAG(s, roots, func(key mh.Multihash, _ []byte, err error) error {
+		if err == ErrNotFound {
+			return nil // not ours to keep.
+		} else if err != nil {
+			return err
+		}
+
+		if !marked[string(key)] {
+			marked[string(key)] = true
+			if markedFn != nil {
+				markedFn(key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return marked, nil
+}
+
+// walkDAG calls visit once for every block reachable from roots, in depth
+// first order, following the links of each node sorted by path. If the block
+// could not be loaded or decoded, visit is called with the error and the
+// block's links are not followed. Any error returned by visit halts the walk
+// early.
+func walkDAG(s Store, roots []mh.Multihash, visit func(key mh.Multihash, block []byte, err error) error) error {
+	visited := map[string]bool{}
+
+	var stack []mh.Multihash
+	for i := len(roots) - 1; i >= 0; i-- {
+		stack = append(stack, roots[i])
+	}
+
+	for len(stack) > 0 {
+		key := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[string(key)] {
+			continue
+		}
+		visited[string(key)] = true
+
+		var n ipld.Node
+		block, loadErr := s.Get(key)
+		if loadErr == nil {
+			n, loadErr = decodeNode(block)
+		}
+		if err := visit(key, block, loadErr); err != nil {
+			return err
+		} else if loadErr != nil {
+			continue
+		}
+
+		links := n.Links()
+		paths := make([]string, 0, len(links))
+		for p := range links {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+
+		for i := len(paths) - 1; i >= 0; i-- {
+			h, err := links[paths[i]].Hash()
+			if err != nil {
+				return err
+			}
+			stack = append(stack, h)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"io/ioutil"
+	"testing"
+
+	mh "github.com/jbenet/go-multihash"
+
+	ipld "github.com/ipfs/go-ipld"
+	git "github.com/ipfs/go-ipld/coding/git"
+)
+
+func TestGC(t *testing.T) {
+	s := NewMapStore()
+	root, mid, leaf, other := makeDAG(t, s)
+	garbage := mustPut(t, s, ipld.Node{"value": "garbage"})
+	garbageParent := mustPut(t, s, ipld.Node{"child": link(garbage)})
+
+	// a dangling link must not prevent the collection
+	missing, _ := Hash([]byte("missing"))
+	dangling := mustPut(t, s, ipld.Node{"missing": link(missing), "leaf": link(leaf)})
+
+	var marked, swept int
+	opts := &GCOptions{
+		DryRun: true,
+		Marked: func(mh.Multihash) { marked++ },
+		Swept:  func(mh.Multihash) { swept++ },
+	}
+
+	removed, err := GC(s, []mh.Multihash{root, dangling}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 || marked != 5 || swept != 2 {
+		t.Errorf("dry run: removed %d blocks, marked %d, swept %d", len(removed), marked, swept)
+	}
+	if ok, _ := s.Has(garbage); !ok {
+		t.Error("dry run removed a block")
+	}
+
+	removed, err = GC(s, []mh.Multihash{root}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 3 {
+		t.Errorf("expected 3 blocks to be removed, got %d", len(removed))
+	}
+	for _, k := range []mh.Multihash{garbage, garbageParent, dangling} {
+		if ok, _ := s.Has(k); ok {
+			t.Errorf("unreachable block %s was kept", k.B58String())
+		}
+	}
+	for _, k := range []mh.Multihash{root, mid, leaf, other} {
+		if ok, _ := s.Has(k); !ok {
+			t.Errorf("reachable block %s was removed", k.B58String())
+		}
+	}
+
+	if _, err := GC(s, []mh.Multihash{missing}, nil); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for a missing root, got %v", err)
+	}
+}
+
+// Git links are git object ids, which are not store keys, so the store does
+// not follow them.
+func TestGCGitLinks(t *testing.T) {
+	s := NewMapStore()
+	blob := ipld.Node{ipld.CodecKey: "/git", ipld.TypeKey: "blob", "data": []byte("hello\n")}
+	blobID, err := git.Hash(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobKey := mustPut(t, s, blob)
+	tree := mustPut(t, s, ipld.Node{
+		ipld.CodecKey: "/git",
+		ipld.TypeKey:  "tree",
+		"hello.txt":   ipld.Node{ipld.LinkKey: blobID.B58String(), "mode": "100644"},
+	})
+	if string(blobID) == string(blobKey) {
+		t.Fatal("git object id is the store key")
+	}
+
+	if err := Export(s, []mh.Multihash{tree}, ioutil.Discard); err == nil {
+		t.Error("exported a git tree, whose blob has no store key")
+	}
+
+	removed, err := GC(s, []mh.Multihash{tree}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || string(removed[0]) != string(blobKey) {
+		t.Errorf("expected the blob to be removed, got %v", removed)
+	}
+}
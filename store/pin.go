@@ -0,0 +1,270 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	mh "github.com/jbenet/go-multihash"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+// PinMode tells how a block is pinned.
+type PinMode int
+
+const (
+	NotPinned PinMode = iota
+	Direct            // the block itself is pinned
+	Recursive         // the block and everything reachable from it is pinned
+	Indirect          // the block is reachable from a recursive pin
+)
+
+func (m PinMode) String() string {
+	switch m {
+	case Direct:
+		return "direct"
+	case Recursive:
+		return "recursive"
+	case Indirect:
+		return "indirect"
+	default:
+		return "not pinned"
+	}
+}
+
+// Keys of the pin set node.
+const (
+	pinDirectKey    = "direct"
+	pinRecursiveKey = "recursive"
+)
+
+var (
+	ErrNotPinned          = errors.New("block is not pinned")
+	ErrPinnedRecursively  = errors.New("block is already pinned recursively")
+	errInvalidPinSet      = errors.New("invalid pin set node")
+	errFoundIndirectBlock = errors.New("found indirectly pinned block")
+)
+
+// Pinner keeps track of the pinned blocks of a Store. Pinned blocks are
+// protected from garbage collection, see Pinner.GC.
+//
+// The pin set is itself persisted in the store, as an IPLD node of the form:
+//
+//	{
+//	  "direct": [ "<multihash>", ... ],
+//	  "recursive": [ { "mlink": "<multihash>" }, ... ]
+//	}
+//
+// Recursive pins are merkle-links, so that the pin set node is the root of
+// everything it protects. Direct pins are plain strings, as their links must
+// not be followed.
+type Pinner struct {
+	store     Store
+	direct    map[string]mh.Multihash
+	recursive map[string]mh.Multihash
+}
+
+// NewPinner returns a Pinner with an empty pin set.
+func NewPinner(s Store) *Pinner {
+	return &Pinner{
+		store:     s,
+		direct:    map[string]mh.Multihash{},
+		recursive: map[string]mh.Multihash{},
+	}
+}
+
+// LoadPinner returns a Pinner with the pin set stored at key, as returned by
+// a previous call to Flush.
+func LoadPinner(s Store, key mh.Multihash) (*Pinner, error) {
+	n, err := GetNode(s, key)
+	if err != nil {
+		return nil, err
+	}
+
+	p := NewPinner(s)
+
+	if v, ok := n[pinDirectKey]; ok {
+		direct, ok := v.([]interface{})
+		if !ok {
+			return nil, errInvalidPinSet
+		}
+		for _, d := range direct {
+			ds, ok := d.(string)
+			if !ok {
+				return nil, errInvalidPinSet
+			}
+			h, err := mh.FromB58String(ds)
+			if err != nil {
+				return nil, err
+			}
+			p.direct[string(h)] = h
+		}
+	}
+
+	if v, ok := n[pinRecursiveKey]; ok {
+		recursive, ok := v.([]interface{})
+		if !ok {
+			return nil, errInvalidPinSet
+		}
+		for _, r := range recursive {
+			l, ok := ipld.LinkCast(r)
+			if !ok {
+				return nil, errInvalidPinSet
+			}
+			h, err := l.Hash()
+			if err != nil {
+				return nil, err
+			}
+			p.recursive[string(h)] = h
+		}
+	}
+
+	return p, nil
+}
+
+// Pin pins the block at key, which must be in the store. A recursive pin
+// protects every block reachable from key, a direct pin only key itself.
+// Pinning recursively a directly pinned block upgrades the pin.
+func (p *Pinner) Pin(key mh.Multihash, recursive bool) error {
+	if ok, err := p.store.Has(key); err != nil {
+		return err
+	} else if !ok {
+		return ErrNotFound
+	}
+
+	if recursive {
+		delete(p.direct, string(key))
+		p.recursive[string(key)] = key
+		return nil
+	}
+
+	if _, ok := p.recursive[string(key)]; ok {
+		return ErrPinnedRecursively
+	}
+	p.direct[string(key)] = key
+	return nil
+}
+
+// Unpin removes the direct or recursive pin of key. Indirectly pinned blocks
+// cannot be unpinned.
+func (p *Pinner) Unpin(key mh.Multihash) error {
+	if _, ok := p.recursive[string(key)]; ok {
+		delete(p.recursive, string(key))
+		return nil
+	}
+	if _, ok := p.direct[string(key)]; ok {
+		delete(p.direct, string(key))
+		return nil
+	}
+	return ErrNotPinned
+}
+
+// IsPinned returns how key is pinned. Finding out whether a block is pinned
+// indirectly requires walking all the recursive pins.
+func (p *Pinner) IsPinned(key mh.Multihash) (PinMode, error) {
+	if _, ok := p.recursive[string(key)]; ok {
+		return Recursive, nil
+	}
+	if _, ok := p.direct[string(key)]; ok {
+		return Direct, nil
+	}
+
+	err := walkDAG(p.store, p.RecursiveKeys(), func(k mh.Multihash, _ []byte, err error) error {
+		if err == ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		} else if string(k) == string(key) {
+			return errFoundIndirectBlock
+		}
+		return nil
+	})
+	if err == errFoundIndirectBlock {
+		return Indirect, nil
+	} else if err != nil {
+		return NotPinned, err
+	}
+	return NotPinned, nil
+}
+
+// DirectKeys returns the directly pinned keys.
+func (p *Pinner) DirectKeys() []mh.Multihash {
+	return sortedKeys(p.direct)
+}
+
+// RecursiveKeys returns the recursively pinned keys.
+func (p *Pinner) RecursiveKeys() []mh.Multihash {
+	return sortedKeys(p.recursive)
+}
+
+// Verify checks that every pinned block is in the store, and that the DAGs
+// below recursive pins are complete and can be decoded. The returned error
+// names the first broken pin found.
+func (p *Pinner) Verify() error {
+	for _, k := range p.DirectKeys() {
+		if ok, err := p.store.Has(k); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("direct pin %s: %s", k.B58String(), ErrNotFound)
+		}
+	}
+
+	for _, root := range p.RecursiveKeys() {
+		err := walkDAG(p.store, []mh.Multihash{root}, func(k mh.Multihash, _ []byte, err error) error {
+			if err != nil {
+				return fmt.Errorf("recursive pin %s: block %s: %s", root.B58String(), k.B58String(), err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes the pin set to the store and returns its key, which can be
+// given to LoadPinner.
+func (p *Pinner) Flush() (mh.Multihash, error) {
+	direct := []interface{}{}
+	for _, k := range p.DirectKeys() {
+		direct = append(direct, k.B58String())
+	}
+
+	recursive := []interface{}{}
+	for _, k := range p.RecursiveKeys() {
+		recursive = append(recursive, ipld.Node{ipld.LinkKey: k.B58String()})
+	}
+
+	return PutNode(p.store, ipld.Node{
+		pinDirectKey:    direct,
+		pinRecursiveKey: recursive,
+	})
+}
+
+// GC is like the GC function, but it flushes the pin set first and keeps the
+// pinned blocks along with the pin set node itself.
+func (p *Pinner) GC(opts *GCOptions) ([]mh.Multihash, error) {
+	key, err := p.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	return collect(p.store, []mh.Multihash{key}, p.DirectKeys(), opts)
+}
+
+// sortedKeys returns the values of m, sorted by key.
+func sortedKeys(m map[string]mh.Multihash) []mh.Multihash {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+
+	res := make([]mh.Multihash, len(ks))
+	for i, k := range ks {
+		res[i] = m[k]
+	}
+	return res
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"testing"
+
+	mh "github.com/jbenet/go-multihash"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+func TestPinner(t *testing.T) {
+	s := NewMapStore()
+	root, mid, leaf, other := makeDAG(t, s)
+	direct := mustPut(t, s, ipld.Node{"child": link(other)})
+	garbage := mustPut(t, s, ipld.Node{"value": "garbage"})
+
+	p := NewPinner(s)
+	if err := p.Pin(mid, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Pin(direct, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Pin(mid, false); err != ErrPinnedRecursively {
+		t.Errorf("expected ErrPinnedRecursively, got %v", err)
+	}
+
+	missing, _ := Hash([]byte("missing"))
+	if err := p.Pin(missing, true); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	for k, mode := range map[string]PinMode{
+		string(mid):    Recursive,
+		string(leaf):   Indirect,
+		string(direct): Direct,
+		string(other):  NotPinned,
+		string(root):   NotPinned,
+	} {
+		m, err := p.IsPinned(mh.Multihash(k))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m != mode {
+			t.Errorf("%s: expected %s, got %s", mh.Multihash(k).B58String(), mode, m)
+		}
+	}
+
+	if err := p.Verify(); err != nil {
+		t.Error(err)
+	}
+
+	// the pin set survives a round-trip through the store
+	key, err := p.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = LoadPinner(s, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rk := p.RecursiveKeys(); len(rk) != 1 || string(rk[0]) != string(mid) {
+		t.Error("recursive pins were not loaded")
+	}
+	if dk := p.DirectKeys(); len(dk) != 1 || string(dk[0]) != string(direct) {
+		t.Error("direct pins were not loaded")
+	}
+
+	removed, err := p.GC(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []mh.Multihash{root, other, garbage} {
+		if ok, _ := s.Has(k); ok {
+			t.Errorf("unpinned block %s was kept", k.B58String())
+		}
+	}
+	for _, k := range []mh.Multihash{mid, leaf, direct, key} {
+		if ok, _ := s.Has(k); !ok {
+			t.Errorf("pinned block %s was removed", k.B58String())
+		}
+	}
+	if len(removed) != 3 {
+		t.Errorf("expected 3 blocks to be removed, got %d", len(removed))
+	}
+
+	// direct pins do not protect their children
+	if err := p.Verify(); err != nil {
+		t.Error(err)
+	}
+
+	if err := p.Unpin(leaf); err != ErrNotPinned {
+		t.Errorf("expected ErrNotPinned, got %v", err)
+	}
+	if err := p.Unpin(mid); err != nil {
+		t.Fatal(err)
+	}
+	s.Delete(leaf)
+	if err := p.Pin(mid, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Verify(); err == nil {
+		t.Error("incomplete recursive pin was verified")
+	}
+}
@@ -0,0 +1,209 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+
+	mc "github.com/jbenet/go-multicodec"
+	mh "github.com/jbenet/go-multihash"
+
+	ipld "github.com/ipfs/go-ipld"
+	coding "github.com/ipfs/go-ipld/coding"
+	pb "github.com/ipfs/go-ipld/coding/pb"
+)
+
+// SizeKey is the link property holding the cumulative size of the target of
+// the link, like the Tsize of merkledag protobuf links.
+const SizeKey = "size"
+
+// SizeError is returned by VerifySizes for a link whose size is missing or
+// is not the cumulative size of its target.
+type SizeError struct {
+	Key      mh.Multihash // the block holding the link
+	Path     string       // the path of the link in the block
+	Size     interface{}  // the size property of the link, nil if missing
+	Expected uint64       // the cumulative size of the target
+}
+
+func (e *SizeError) Error() string {
+	if e.Size == nil {
+		return fmt.Sprintf("link %s of %s has no size, expected %d", e.Path, e.Key.B58String(), e.Expected)
+	}
+	return fmt.Sprintf("link %s of %s has size %v, expected %d", e.Path, e.Key.B58String(), e.Size, e.Expected)
+}
+
+// CumulativeSize returns the cumulative size of the DAG stored in s below
+// key: the size of its block, plus the cumulative sizes of the targets of
+// its links. As for the Tsize of merkledag protobuf links, blocks reached by
+// several links are counted once per link. All the blocks must be present.
+//
+// The links followed are the merkle-links of the nodes, as returned by
+// Node.Links(), except for the merkledag protobuf nodes whose links are the
+// ones of @attrs.links, which also include the links without a name.
+func CumulativeSize(s Store, key mh.Multihash) (uint64, error) {
+	d := &dagSizer{store: s, sizes: map[string]uint64{}}
+	return d.size(key, nil)
+}
+
+// VerifySizes checks that the size property of every link reachable from
+// root is the cumulative size of its target, see CumulativeSize. It returns
+// a *SizeError for the first link found to be wrong, in depth first order.
+func VerifySizes(s Store, root mh.Multihash) error {
+	d := &dagSizer{store: s, sizes: map[string]uint64{}}
+	_, err := d.size(root, func(key mh.Multihash, p string, l ipld.Link, size uint64) (bool, error) {
+		if v, ok := pb.Uint64Value(l[SizeKey]); !ok || v != size {
+			return false, &SizeError{key, p, l[SizeKey], size}
+		}
+		return false, nil
+	})
+	return err
+}
+
+// FillSizes sets the size property of every link reachable from root to the
+// cumulative size of its target, see CumulativeSize. Like Transform, it
+// writes the blocks that changed to the store, leaving the original blocks
+// in place, and returns the key of the new root.
+func FillSizes(s Store, root mh.Multihash) (mh.Multihash, error) {
+	d := &dagSizer{store: s, sizes: map[string]uint64{}, done: map[string]mh.Multihash{}}
+	if _, err := d.size(root, fillSize); err != nil {
+		return nil, err
+	}
+	return d.done[string(root)], nil
+}
+
+// fillSize sets the size of the link l, if it is not already right.
+func fillSize(_ mh.Multihash, _ string, l ipld.Link, size uint64) (bool, error) {
+	if v, ok := pb.Uint64Value(l[SizeKey]); ok && v == size {
+		return false, nil
+	}
+	l[SizeKey] = size
+	return true, nil
+}
+
+// linkFunc is called by dagSizer for every link of the block at key, at path
+// p, once the cumulative size of its target is known. It may modify the link,
+// in which case it returns true.
+type linkFunc func(key mh.Multihash, p string, l ipld.Link, size uint64) (bool, error)
+
+// dagSizer holds the state of a cumulative size computation.
+type dagSizer struct {
+	store Store
+
+	// sizes maps the keys of the blocks already visited to their cumulative
+	// size, and done to their new key if they are rewritten.
+	sizes map[string]uint64
+	done  map[string]mh.Multihash
+}
+
+// size returns the cumulative size of the DAG below key, calling linkFn, if
+// not nil, for every link. If d.done is not nil, the blocks whose links were
+// modified by linkFn, or point to blocks which were rewritten, are rewritten
+// as well, and the returned size is the one of the new DAG.
+func (d *dagSizer) size(key mh.Multihash, linkFn linkFunc) (uint64, error) {
+	if size, ok := d.sizes[string(key)]; ok {
+		return size, nil
+	}
+
+	block, err := d.store.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := decodeNode(block)
+	if err != nil {
+		return 0, err
+	}
+
+	links := sizedLinks(n)
+	paths := make([]string, 0, len(links))
+	for p := range links {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var size uint64
+	changed := false
+	for _, p := range paths {
+		l := links[p]
+		h, err := l.Hash()
+		if err != nil {
+			return 0, err
+		}
+
+		lsize, err := d.size(h, linkFn)
+		if err != nil {
+			return 0, err
+		}
+		size += lsize
+
+		if d.done != nil {
+			if newh := d.done[string(h)]; string(newh) != string(h) {
+				setLinkHash(l, newh)
+				changed = true
+			}
+		}
+		if linkFn != nil {
+			modified, err := linkFn(key, p, l, lsize)
+			if err != nil {
+				return 0, err
+			}
+			changed = changed || modified
+		}
+	}
+
+	newkey := key
+	if changed && d.done != nil {
+		block, err = mc.Marshal(coding.Multicodec(), &n)
+		if err != nil {
+			return 0, err
+		}
+		if newkey, err = Hash(block); err != nil {
+			return 0, err
+		}
+		if err := d.store.Put(newkey, block); err != nil {
+			return 0, err
+		}
+	}
+
+	size += uint64(len(block))
+	d.sizes[string(key)] = size
+	if d.done != nil {
+		d.done[string(key)] = newkey
+	}
+	return size, nil
+}
+
+// sizedLinks returns the links of n, keyed by path, which can be modified in
+// place. They are the merkle-links of n, except for the nodes decoded by the
+// merkledag protobuf codec, whose links are the ones of @attrs.links, keyed
+// by their offset. The protobuf codec shares them with the top-level entries
+// of the node, which are then modified as well.
+func sizedLinks(n ipld.Node) map[string]ipld.Link {
+	attrs, _ := n["@attrs"].(ipld.Node)
+	if pblinks, ok := attrs["links"].([]ipld.Node); ok {
+		links := make(map[string]ipld.Link, len(pblinks))
+		for i, l := range pblinks {
+			links[fmt.Sprintf("@attrs/links/%d", i)] = ipld.Link(l)
+		}
+		return links
+	}
+
+	// Node.Links returns copies of the links
+	links := n.Links()
+	for p := range links {
+		if p == "" {
+			links[p] = ipld.Link(n)
+		} else {
+			links[p] = ipld.Link(ipld.GetPath(n, "/"+p).(ipld.Node))
+		}
+	}
+	return links
+}
+
+// setLinkHash makes l point to h. The links decoded by the merkledag protobuf
+// codec also hold their hash as bytes, which must agree.
+func setLinkHash(l ipld.Link, h mh.Multihash) {
+	l[ipld.LinkKey] = h.B58String()
+	if _, ok := l["hash"].([]byte); ok {
+		l["hash"] = []byte(h)
+	}
+}
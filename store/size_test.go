@@ -0,0 +1,128 @@
+package store
+
+import (
+	"testing"
+
+	mh "github.com/jbenet/go-multihash"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+func blockSize(t *testing.T, s Store, key mh.Multihash) uint64 {
+	block, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uint64(len(block))
+}
+
+func TestCumulativeSize(t *testing.T) {
+	s := NewMapStore()
+	root, mid, leaf, other := makeDAG(t, s)
+
+	// the shared leaf is counted twice
+	expected := blockSize(t, s, root) + blockSize(t, s, mid) + 2*blockSize(t, s, leaf) + blockSize(t, s, other)
+	if size, err := CumulativeSize(s, root); err != nil {
+		t.Fatal(err)
+	} else if size != expected {
+		t.Errorf("cumulative size is %d, expected %d", size, expected)
+	}
+
+	if err := VerifySizes(s, root); err == nil {
+		t.Fatal("verified links without sizes")
+	} else if serr, ok := err.(*SizeError); !ok || serr.Size != nil || serr.Path != "leaf" || serr.Expected != blockSize(t, s, leaf) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	newRoot, err := FillSizes(s, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySizes(s, newRoot); err != nil {
+		t.Error(err)
+	}
+
+	n, err := GetNode(s, newRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newMid, _ := ipld.Link(n["mid"].(ipld.Node)).Hash()
+	for p, expected := range map[string]uint64{
+		"leaf":  blockSize(t, s, leaf),
+		"other": blockSize(t, s, other),
+		"mid":   blockSize(t, s, newMid) + blockSize(t, s, leaf),
+	} {
+		if size := n[p].(ipld.Node)[SizeKey]; size != int64(expected) {
+			t.Errorf("%s: size %v, expected %d", p, size, expected)
+		}
+	}
+
+	// filling is idempotent
+	if again, err := FillSizes(s, newRoot); err != nil {
+		t.Fatal(err)
+	} else if string(again) != string(newRoot) {
+		t.Error("filled sizes changed again")
+	}
+
+	// wrong sizes are reported
+	n["other"].(ipld.Node)[SizeKey] = int64(1)
+	wrong := mustPut(t, s, n)
+	if err := VerifySizes(s, wrong); err == nil {
+		t.Error("verified a wrong size")
+	} else if serr, ok := err.(*SizeError); !ok || serr.Size != int64(1) || serr.Path != "other" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	h, _ := Hash([]byte("not stored"))
+	if _, err := CumulativeSize(s, h); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestProtobufSizes(t *testing.T) {
+	s := NewMapStore()
+	chunk := mustPut(t, s, ipld.Node{
+		"@attrs": ipld.Node{"data": []byte("file chunk")},
+	})
+	child := mustPut(t, s, ipld.Node{"value": "child"})
+
+	pblink := func(h mh.Multihash, name string) ipld.Node {
+		return ipld.Node{ipld.LinkKey: h.B58String(), "hash": []byte(h), "name": name, "size": uint64(0)}
+	}
+	named := pblink(child, "child")
+	root := mustPut(t, s, ipld.Node{
+		"@attrs": ipld.Node{
+			"data":  []byte{},
+			"links": []ipld.Node{pblink(chunk, ""), named},
+		},
+		"child": named,
+	})
+
+	// the link without a name is followed too
+	expected := blockSize(t, s, root) + blockSize(t, s, chunk) + blockSize(t, s, child)
+	if size, err := CumulativeSize(s, root); err != nil {
+		t.Fatal(err)
+	} else if size != expected {
+		t.Errorf("cumulative size is %d, expected %d", size, expected)
+	}
+
+	newRoot, err := FillSizes(s, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySizes(s, newRoot); err != nil {
+		t.Error(err)
+	}
+
+	n, err := GetNode(s, newRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := n["@attrs"].(ipld.Node)["links"].([]ipld.Node)
+	if links[0]["size"] != blockSize(t, s, chunk) || links[1]["size"] != blockSize(t, s, child) {
+		t.Errorf("unexpected links: %v", links)
+	}
+	if n["child"].(ipld.Node)["size"] != blockSize(t, s, child) {
+		t.Errorf("unexpected top-level link: %v", n["child"])
+	}
+}
@@ -0,0 +1,118 @@
+// Package store provides a content-addressed block store for IPLD nodes and
+// the operations that need one, such as following merkle-links across blocks.
+package store
+
+import (
+	"errors"
+
+	mc "github.com/jbenet/go-multicodec"
+	mh "github.com/jbenet/go-multihash"
+
+	ipld "github.com/ipfs/go-ipld"
+	coding "github.com/ipfs/go-ipld/coding"
+)
+
+// ErrNotFound is returned when a block is not present in a Store.
+var ErrNotFound = errors.New("block not found")
+
+// Store is a content-addressed block store. A block is the encoded form of a
+// node, as produced by coding.Multicodec(), and it is keyed by the multihash
+// of its bytes.
+type Store interface {
+	// Get returns the block stored at key, or ErrNotFound.
+	Get(key mh.Multihash) ([]byte, error)
+
+	// Put stores the block at key. It is up to the caller to make sure the
+	// key is the hash of the block, see PutNode.
+	Put(key mh.Multihash, block []byte) error
+
+	// Has returns whether a block is stored at key.
+	Has(key mh.Multihash) (bool, error)
+
+	// Delete removes the block stored at key. Deleting a block which is not
+	// in the store is not an error.
+	Delete(key mh.Multihash) error
+
+	// Keys returns the keys of all the blocks in the store.
+	Keys() ([]mh.Multihash, error)
+}
+
+// MapStore is a Store keeping its blocks in memory.
+type MapStore struct {
+	blocks map[string][]byte
+}
+
+// NewMapStore returns an empty in-memory Store.
+func NewMapStore() *MapStore {
+	return &MapStore{blocks: map[string][]byte{}}
+}
+
+func (s *MapStore) Get(key mh.Multihash) ([]byte, error) {
+	block, ok := s.blocks[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return block, nil
+}
+
+func (s *MapStore) Put(key mh.Multihash, block []byte) error {
+	s.blocks[string(key)] = block
+	return nil
+}
+
+func (s *MapStore) Has(key mh.Multihash) (bool, error) {
+	_, ok := s.blocks[string(key)]
+	return ok, nil
+}
+
+func (s *MapStore) Delete(key mh.Multihash) error {
+	delete(s.blocks, string(key))
+	return nil
+}
+
+func (s *MapStore) Keys() ([]mh.Multihash, error) {
+	keys := make([]mh.Multihash, 0, len(s.blocks))
+	for k := range s.blocks {
+		keys = append(keys, mh.Multihash(k))
+	}
+	return keys, nil
+}
+
+// Hash returns the multihash used as the key of the given block.
+func Hash(block []byte) (mh.Multihash, error) {
+	return mh.Sum(block, mh.SHA2_256, -1)
+}
+
+// GetNode retrieves the block at key and decodes it.
+func GetNode(s Store, key mh.Multihash) (ipld.Node, error) {
+	block, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return decodeNode(block)
+}
+
+// decodeNode decodes a block retrieved from a Store.
+func decodeNode(block []byte) (ipld.Node, error) {
+	var n ipld.Node
+	if err := mc.Unmarshal(coding.Multicodec(), block, &n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// PutNode encodes the node, stores it and returns the key under which it was
+// stored.
+func PutNode(s Store, n ipld.Node) (mh.Multihash, error) {
+	block, err := mc.Marshal(coding.Multicodec(), &n)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := Hash(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, s.Put(key, block)
+}
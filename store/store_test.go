@@ -0,0 +1,246 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	mh "github.com/jbenet/go-multihash"
+
+	ipld "github.com/ipfs/go-ipld"
+	coding "github.com/ipfs/go-ipld/coding"
+	pb "github.com/ipfs/go-ipld/coding/pb"
+)
+
+func link(h mh.Multihash) ipld.Node {
+	return ipld.Node{ipld.LinkKey: h.B58String()}
+}
+
+func mustPut(t *testing.T, s Store, n ipld.Node) mh.Multihash {
+	h, err := PutNode(s, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// makeDAG stores the following DAG, where leaf is shared:
+//
+//	root -> mid -> leaf
+//	     -> leaf
+//	     -> other
+func makeDAG(t *testing.T, s Store) (root, mid, leaf, other mh.Multihash) {
+	leaf = mustPut(t, s, ipld.Node{"value": "leaf"})
+	other = mustPut(t, s, ipld.Node{"value": "other"})
+	mid = mustPut(t, s, ipld.Node{
+		"name": "mid",
+		"leaf": link(leaf),
+	})
+	root = mustPut(t, s, ipld.Node{
+		"mid":   link(mid),
+		"leaf":  link(leaf),
+		"other": link(other),
+	})
+	return
+}
+
+func TestGetPutNode(t *testing.T) {
+	s := NewMapStore()
+	n := ipld.Node{"foo": "bar"}
+	h := mustPut(t, s, n)
+
+	n2, err := GetNode(s, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n2["foo"] != "bar" {
+		t.Errorf("stored node not retrieved: %#v", n2)
+	}
+
+	h2, _ := Hash([]byte("not stored"))
+	if _, err := GetNode(s, h2); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRawLeaf(t *testing.T) {
+	s := NewMapStore()
+	data := []byte("file chunk")
+	leaf := mustPut(t, s, coding.RawNode(data))
+
+	// the block is the data itself
+	if h, _ := Hash(data); string(h) != string(leaf) {
+		t.Error("raw leaf key is not the hash of its data")
+	}
+	if block, _ := s.Get(leaf); !bytes.Equal(block, data) {
+		t.Errorf("raw leaf stored as %q", block)
+	}
+
+	root := mustPut(t, s, ipld.Node{"chunk": link(leaf)})
+	var visited []string
+	err := walkDAG(s, []mh.Multihash{root}, func(k mh.Multihash, _ []byte, err error) error {
+		visited = append(visited, k.B58String())
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(visited) != 2 || visited[1] != leaf.B58String() {
+		t.Errorf("raw leaf not reached: %v", visited)
+	}
+
+	n, err := GetNode(s, leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := n.RawData(); !ok || !bytes.Equal(d, data) {
+		t.Errorf("raw leaf decoded as %#v", n)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	s := NewMapStore()
+	root, _, leaf, other := makeDAG(t, s)
+
+	calls := map[string]int{}
+	newroot, err := Transform(s, root, func(root, curr ipld.Node, path []string, err error) (ipld.Node, error) {
+		if len(path) == 0 {
+			calls[fmt.Sprint(curr["value"], curr["name"])]++
+		}
+		if curr["value"] == "leaf" {
+			return ipld.Node{"value": "LEAF"}, err
+		}
+		return curr, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for block, n := range calls {
+		if n != 1 {
+			t.Errorf("block %q transformed %d times", block, n)
+		}
+	}
+	if len(calls) != 4 {
+		t.Errorf("expected 4 blocks to be transformed, got %d", len(calls))
+	}
+
+	if string(newroot) == string(root) {
+		t.Fatal("root was not changed")
+	}
+
+	n, err := GetNode(s, newroot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := n.Links()
+
+	newleaf, _ := links["leaf"].Hash()
+	if string(newleaf) == string(leaf) {
+		t.Error("leaf link was not updated")
+	}
+	if h, _ := links["other"].Hash(); string(h) != string(other) {
+		t.Error("unchanged block got a new key")
+	}
+
+	newmid, _ := links["mid"].Hash()
+	midn, err := GetNode(s, newmid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, _ := midn.Links()["leaf"].Hash(); string(h) != string(newleaf) {
+		t.Error("shared leaf transformed differently")
+	}
+
+	leafn, err := GetNode(s, newleaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leafn["value"] != "LEAF" {
+		t.Errorf("leaf not transformed: %#v", leafn)
+	}
+
+	// the original DAG is still there
+	if leafn, err := GetNode(s, leaf); err != nil || leafn["value"] != "leaf" {
+		t.Error("original leaf was lost")
+	}
+}
+
+func TestTransformUnchanged(t *testing.T) {
+	s := NewMapStore()
+	root, _, _, _ := makeDAG(t, s)
+
+	newroot, err := Transform(s, root, func(root, curr ipld.Node, path []string, err error) (ipld.Node, error) {
+		return curr, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(newroot) != string(root) {
+		t.Error("identity transform changed the root")
+	}
+}
+
+func TestTransformProtobuf(t *testing.T) {
+	s := NewMapStore()
+	chunk := mustPut(t, s, ipld.Node{"value": "chunk"})
+	child := mustPut(t, s, ipld.Node{"value": "child"})
+
+	pblink := func(h mh.Multihash, name string) ipld.Node {
+		return ipld.Node{ipld.LinkKey: h.B58String(), "hash": []byte(h), "name": name, "size": uint64(0)}
+	}
+	named := pblink(child, "child")
+	root := mustPut(t, s, ipld.Node{
+		"@attrs": ipld.Node{
+			"data":  []byte("data"),
+			"links": []ipld.Node{pblink(chunk, ""), named},
+		},
+		"child": named,
+	})
+
+	newroot, err := Transform(s, root, func(root, curr ipld.Node, path []string, err error) (ipld.Node, error) {
+		if curr["value"] == "child" {
+			return ipld.Node{"value": "CHILD"}, err
+		}
+		return curr, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the new root is still a protobuf node, with consistent links
+	block, err := s.Get(newroot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(block[len(coding.Multicodec().Header()):], pb.Header) {
+		t.Fatalf("new root not encoded as protobuf: %q", block)
+	}
+	n, err := GetNode(s, newroot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newchild, err := ipld.Link(n["child"].(ipld.Node)).Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(newchild) == string(child) {
+		t.Fatal("child link was not updated")
+	}
+	links := n["@attrs"].(ipld.Node)["links"].([]ipld.Node)
+	if !bytes.Equal(links[1]["hash"].([]byte), newchild) || !bytes.Equal(links[0]["hash"].([]byte), chunk) {
+		t.Errorf("unexpected links: %v", links)
+	}
+	if childn, err := GetNode(s, newchild); err != nil || childn["value"] != "CHILD" {
+		t.Errorf("child not transformed: %v", childn)
+	}
+
+	// a protobuf node with inconsistent links is not silently stored as CBOR
+	named[ipld.LinkKey] = chunk.B58String()
+	if _, err := PutNode(s, ipld.Node{
+		"@attrs": ipld.Node{"data": []byte{}, "links": []ipld.Node{named}},
+		"child":  named,
+	}); err == nil {
+		t.Error("stored a protobuf node with inconsistent links")
+	}
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"reflect"
+	"strings"
+
+	mh "github.com/jbenet/go-multihash"
+
+	ipld "github.com/ipfs/go-ipld"
+)
+
+// Transform is like ipld.Transform, but it operates on the DAG stored in s
+// below the root key, following merkle-links across blocks.
+//
+// The TransformFunc is applied to every block reachable from root, before its
+// links are followed, so links returned by the TransformFunc are the ones
+// followed. For each call, the root argument is the node of the block being
+// transformed and the path is relative to it. Blocks shared by several parents
+// are only transformed once.
+//
+// Every block that changed, either because the TransformFunc modified it or
+// because one of its links now points to a changed block, is written back to
+// the store and the links of all its ancestors are updated accordingly. The
+// original blocks are left in the store. Transform returns the key of the new
+// root, which is the old root key if nothing changed.
+func Transform(s Store, root mh.Multihash, transformFn ipld.TransformFunc) (mh.Multihash, error) {
+	t := &dagTransformer{
+		store:       s,
+		transformFn: transformFn,
+		done:        map[string]mh.Multihash{},
+	}
+	return t.transform(root)
+}
+
+// dagTransformer holds the state of a Transform call.
+type dagTransformer struct {
+	store       Store
+	transformFn ipld.TransformFunc
+
+	// done maps the keys of the blocks already transformed to their new key.
+	done map[string]mh.Multihash
+}
+
+func (t *dagTransformer) transform(key mh.Multihash) (mh.Multihash, error) {
+	if newkey, ok := t.done[string(key)]; ok {
+		return newkey, nil
+	}
+
+	n, err := GetNode(t.store, key)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := ipld.Transform(n, t.transformFn)
+	if err != nil {
+		return nil, err
+	}
+	changed := !reflect.DeepEqual(n, res)
+
+	// then recurse, and update the links pointing to changed blocks.
+	for p, l := range res.Links() {
+		h, err := l.Hash()
+		if err != nil {
+			return nil, err
+		}
+
+		newh, err := t.transform(h)
+		if err != nil {
+			return nil, err
+		}
+		if string(newh) == string(h) {
+			continue
+		}
+
+		res, err = setLink(res, p, newh)
+		if err != nil {
+			return nil, err
+		}
+		changed = true
+	}
+
+	newkey := key
+	if changed {
+		newkey, err = PutNode(t.store, res)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t.done[string(key)] = newkey
+	return newkey, nil
+}
+
+// setLink returns a copy of n where the link at path p (as returned by
+// ipld.Links) points to h. The other link properties are kept. The links of
+// merkledag protobuf nodes are also held in @attrs.links, where the ones
+// pointing to the same target are updated too.
+func setLink(n ipld.Node, p string, h mh.Multihash) (ipld.Node, error) {
+	var startFrom []string
+	if p != "" {
+		startFrom = strings.Split(p, "/")
+	}
+
+	var old interface{}
+	res, err := ipld.TransformFromRoot(n, startFrom, func(root, curr ipld.Node, path []string, err error) (ipld.Node, error) {
+		old = curr[ipld.LinkKey]
+		l := copyLink(curr)
+		setLinkHash(ipld.Link(l), h)
+		return l, ipld.SkipNode
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, _ := res["@attrs"].(ipld.Node)
+	pblinks, ok := attrs["links"].([]ipld.Node)
+	if !ok {
+		return res, nil
+	}
+	newattrs := copyLink(attrs)
+	newlinks := make([]ipld.Node, len(pblinks))
+	for i, l := range pblinks {
+		newlinks[i] = l
+		if l[ipld.LinkKey] == old {
+			newlinks[i] = copyLink(l)
+			setLinkHash(ipld.Link(newlinks[i]), h)
+		}
+	}
+	newattrs["links"] = newlinks
+	res["@attrs"] = newattrs
+	return res, nil
+}
+
+// copyLink returns a shallow copy of l.
+func copyLink(l ipld.Node) ipld.Node {
+	res := make(ipld.Node, len(l))
+	for k, v := range l {
+		res[k] = v
+	}
+	return res
+}
@@ -49,6 +49,86 @@ func TransformFrom(root Node, startFrom []string, transformFn TransformFunc) (in
 	return transform(root, start, startFrom, transformFn)
 }
 
+// TransformFromRoot is just like TransformFrom, but instead of returning the
+// transformed sub-node alone, it returns a new root in which the sub-node at
+// startFrom has been replaced by its transformed version. Only the nodes and
+// sequences along startFrom are copied, all the untouched siblings are shared
+// with the original root, which is left unmodified.
+//
+// If startFrom does not exist in root, the returned error names the first
+// path component that could not be found.
+func TransformFromRoot(root Node, startFrom []string, transformFn TransformFunc) (Node, error) {
+	n, err := transformAt(root, root, startFrom, 0, transformFn)
+	if err != nil {
+		return nil, err
+	}
+	if node, ok := n.(Node); ok {
+		return node, nil
+	} else {
+		return nil, nil
+	}
+}
+
+// transformAt is used to implement TransformFromRoot. It descends curr along
+// startFrom[i:], transforms the sub-node found there and returns a copy of
+// curr with the sub-node replaced.
+func transformAt(root Node, curr interface{}, startFrom []string, i int, transformFn TransformFunc) (interface{}, error) {
+	if i == len(startFrom) {
+		npath := make([]string, len(startFrom))
+		copy(npath, startFrom)
+		return transform(root, curr, npath, transformFn)
+	}
+
+	k := startFrom[i]
+	notFound := errors.New("no descendant at " + path.Join(startFrom...) +
+		": component " + strconv.Quote(k) + " not found")
+
+	if nc, ok := curr.(Node); ok { // it's a node!
+		k = EscapePathComponent(k)
+		child := nc[k]
+		if child == nil {
+			return nil, notFound
+		}
+
+		n, err := transformAt(root, child, startFrom, i+1, transformFn)
+		if err != nil {
+			return nil, err
+		}
+
+		res := make(Node, len(nc))
+		for k2, v := range nc {
+			res[k2] = v
+		}
+		if n != nil {
+			res[k] = n
+		} else {
+			delete(res, k)
+		}
+		return res, nil
+
+	} else if sc, ok := curr.([]interface{}); ok { // it's a slice!
+		idx, err := strconv.Atoi(k)
+		if err != nil || idx < 0 || idx >= len(sc) || sc[idx] == nil {
+			return nil, notFound
+		}
+
+		n, err := transformAt(root, sc[idx], startFrom, i+1, transformFn)
+		if err != nil {
+			return nil, err
+		}
+
+		res := make([]interface{}, 0, len(sc))
+		res = append(res, sc[:idx]...)
+		if n != nil {
+			res = append(res, n)
+		}
+		res = append(res, sc[idx+1:]...)
+		return res, nil
+	}
+
+	return nil, notFound // cannot keep walking...
+}
+
 // transform is used to implement Transform
 func transform(root Node, curr interface{}, npath []string, transformFunc TransformFunc) (interface{}, error) {
 
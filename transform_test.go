@@ -0,0 +1,84 @@
+package ipld
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTransformFromRoot(t *testing.T) {
+	sibling := Node{"mlink": "QmZku7P7KeeHAnwMr6c4HveYfMzmtVinNXzibkiNbfDbPo"}
+	root := Node{
+		"foo": Node{
+			"bar": []interface{}{
+				Node{"a": "aaa"},
+				Node{"b": "bbb"},
+			},
+			"sibling": sibling,
+		},
+		"baz": "baz",
+	}
+
+	res, err := TransformFromRoot(root, []string{"foo", "bar", "1"}, func(root, curr Node, path []string, err error) (Node, error) {
+		if _, ok := curr["b"]; ok {
+			return Node{"b": "BBB"}, err
+		}
+		return curr, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Node{
+		"foo": Node{
+			"bar": []interface{}{
+				Node{"a": "aaa"},
+				Node{"b": "BBB"},
+			},
+			"sibling": sibling,
+		},
+		"baz": "baz",
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Logf("Expected: %#v", expected)
+		t.Logf("Actual:   %#v", res)
+		t.Error("transformed root is not the expected one")
+	}
+
+	if GetPath(root, "/foo/bar/1/b") != "bbb" {
+		t.Error("original root was modified")
+	}
+
+	// untouched siblings must be shared, not copied
+	resSibling := GetPath(res, "/foo/sibling").(Node)
+	if reflect.ValueOf(resSibling).Pointer() != reflect.ValueOf(sibling).Pointer() {
+		t.Error("untouched sibling was copied")
+	}
+}
+
+func TestTransformFromRootMissing(t *testing.T) {
+	root := Node{
+		"foo": Node{
+			"bar": []interface{}{"a", "b"},
+		},
+	}
+
+	identity := func(root, curr Node, path []string, err error) (Node, error) {
+		return curr, err
+	}
+
+	for comp, startFrom := range map[string][]string{
+		"quux": {"foo", "quux", "baz"},
+		"2":    {"foo", "bar", "2"},
+		"x":    {"foo", "bar", "x"},
+	} {
+		_, err := TransformFromRoot(root, startFrom, identity)
+		if err == nil {
+			t.Errorf("expected an error for %v", startFrom)
+			continue
+		}
+		if !strings.Contains(err.Error(), `"`+comp+`"`) {
+			t.Errorf("error %q does not name the missing component %q", err, comp)
+		}
+	}
+}
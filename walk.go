@@ -133,6 +133,15 @@ func walk(root Node, curr interface{}, npath string, walkFunc WalkFunc) error {
 	return nil
 }
 
+// LazyValue is implemented by the maps and lists which are only decoded when
+// accessed, such as the lazy nodes of the coding package. GetPath descends
+// into them.
+type LazyValue interface {
+	// Child returns the child at the path component comp, which is the key of
+	// a map (unescaped) or the offset of a list, or nil if there is none.
+	Child(comp string) interface{}
+}
+
 // GetPath gets a descendant of root, at npath. GetPath
 // uses the UNIX path abstraction: components of a
 // path are delimited with "/". The path MUST start with "/".
@@ -167,6 +176,9 @@ func GetPathCmp(root interface{}, npath []string) interface{} {
 		}
 
 		return GetPathCmp(vs[i], npath[1:])
+
+	} else if lv, ok := root.(LazyValue); ok {
+		return GetPathCmp(lv.Child(k), npath[1:])
 	}
 
 	return nil // cannot keep walking...